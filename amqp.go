@@ -19,21 +19,238 @@ import (
 	"context"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
+	"sync"
+	"time"
 )
 
+//retryCountHeader is a header where number of message processing attempts is tracked
+const retryCountHeader = "x-retry-count"
+
 //AmqpClient is useful interface to deal with amqp connection
+//Restores connection once it's lost and re-declares registered topology
 type AmqpClient struct {
+	mu   sync.RWMutex
 	conn *amqp.Connection
+	//ready is closed once connection is established and topology is declared
+	ready    chan struct{}
+	dial     func() (*amqp.Connection, error)
+	topology []func(ch *amqp.Channel) error
+
+	maxRetries          int
+	retryExchange       string
+	reconnectBackoff    time.Duration
+	maxReconnectBackoff time.Duration
+
+	//consumers tracks state of queue consumers
+	consumers map[string]ConsumerStatus
+}
+
+//States of queue consumer
+const (
+	ConsumerWaiting     = "waiting"
+	ConsumerConsuming   = "consuming"
+	ConsumerInterrupted = "interrupted"
+	ConsumerStopped     = "stopped"
+)
+
+//ConsumerStatus describes state of queue consumer
+type ConsumerStatus struct {
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	Workers int       `json:"workers"`
+	Error   string    `json:"error,omitempty"`
 }
 
 //NewAmqpClient is a factory method for AmqpClient
-func NewAmqpClient(conn *amqp.Connection) *AmqpClient {
-	return &AmqpClient{conn: conn}
+func NewAmqpClient(dial func() (*amqp.Connection, error), cfg *AppConfig) *AmqpClient {
+	return &AmqpClient{
+		ready:               make(chan struct{}),
+		dial:                dial,
+		maxRetries:          cfg.AmqpMaxRetries,
+		retryExchange:       cfg.AmqpRetryExchangeName,
+		reconnectBackoff:    cfg.AmqpReconnectBackoff,
+		maxReconnectBackoff: cfg.AmqpMaxReconnectBackoff,
+	}
+}
+
+//Connect establishes initial connection
+func (a *AmqpClient) Connect() error {
+	conn, err := a.dial()
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to AMQP server")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conn = conn
+	close(a.ready)
+	return nil
+}
+
+//Close closes the connection
+func (a *AmqpClient) Close() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if nil == a.conn {
+		return nil
+	}
+	return a.conn.Close()
+}
+
+//CheckConnection checks connection is open and channel can be opened on it
+func (a *AmqpClient) CheckConnection() (connErr, chErr error) {
+	a.mu.RLock()
+	conn := a.conn
+	a.mu.RUnlock()
+	if nil == conn || conn.IsClosed() {
+		connErr = errors.New("AMQP connection is closed")
+		return connErr, connErr
+	}
+	return nil, a.DoOnChannel(func(*amqp.Channel) error { return nil })
+}
+
+//Consumers reports state of queue consumers
+func (a *AmqpClient) Consumers() map[string]ConsumerStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	consumers := make(map[string]ConsumerStatus, len(a.consumers))
+	for q, s := range a.consumers {
+		consumers[q] = s
+	}
+	return consumers
+}
+
+func (a *AmqpClient) setConsumerState(queue string, workers int, state string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if nil == a.consumers {
+		a.consumers = map[string]ConsumerStatus{}
+	}
+	s := ConsumerStatus{State: state, Since: time.Now(), Workers: workers}
+	if nil != err {
+		s.Error = err.Error()
+	}
+	a.consumers[queue] = s
+}
+
+//OnConnect declares topology and registers it to be declared again once connection is restored
+func (a *AmqpClient) OnConnect(declare func(ch *amqp.Channel) error) error {
+	a.mu.Lock()
+	a.topology = append(a.topology, declare)
+	a.mu.Unlock()
+
+	return a.DoOnChannel(declare)
+}
+
+//Supervise watches the connection and restores it once it's lost
+func (a *AmqpClient) Supervise(ctx context.Context) {
+	for {
+		a.mu.RLock()
+		closed := a.conn.NotifyClose(make(chan *amqp.Error, 1))
+		a.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case amqpErr := <-closed:
+			if nil != ctx.Err() {
+				return
+			}
+			log.Warnf("AMQP connection has been lost: %v", amqpErr)
+
+			a.mu.Lock()
+			a.ready = make(chan struct{})
+			a.mu.Unlock()
+
+			if !a.reconnect(ctx) {
+				return
+			}
+		}
+	}
+}
+
+//reconnect dials with exponential backoff until connection is established and topology is declared
+//returns FALSE if cancelled
+func (a *AmqpClient) reconnect(ctx context.Context) bool {
+	for attempt := 0; ; attempt++ {
+		delay := backoff(attempt, a.reconnectBackoff, a.maxReconnectBackoff)
+		log.Infof("Reconnecting to AMQP server in %v", delay)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		conn, err := a.dial()
+		if err != nil {
+			log.Errorf("Unable to reconnect to AMQP server: %v", err)
+			continue
+		}
+
+		if err := a.declareTopology(conn); err != nil {
+			log.Errorf("Unable to declare AMQP topology: %v", err)
+			if cErr := conn.Close(); cErr != nil {
+				log.Error(cErr)
+			}
+			continue
+		}
+
+		a.mu.Lock()
+		a.conn = conn
+		close(a.ready)
+		a.mu.Unlock()
+		amqpReconnects.Inc()
+		log.Info("Connection to AMQP server has been restored")
+		return true
+	}
+}
+
+func (a *AmqpClient) declareTopology(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return errors.Wrap(err, "Failed to open a channel")
+	}
+	defer func() {
+		if err := ch.Close(); err != nil {
+			log.Errorf("Unable to close opened ampq channel: %v", err)
+		}
+	}()
+
+	a.mu.RLock()
+	topology := a.topology
+	a.mu.RUnlock()
+	for _, declare := range topology {
+		if err := declare(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//awaitConnection blocks until connection is ready
+func (a *AmqpClient) awaitConnection(ctx context.Context) error {
+	a.mu.RLock()
+	ready := a.ready
+	a.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 //DoOnChannel opens amqp channel and execute func
 func (a *AmqpClient) DoOnChannel(chCallback func(channel *amqp.Channel) error) error {
-	ch, err := a.conn.Channel()
+	a.mu.RLock()
+	conn := a.conn
+	a.mu.RUnlock()
+	if nil == conn {
+		return errors.New("AMQP connection is not established")
+	}
+
+	ch, err := conn.Channel()
 	if err != nil {
 		return errors.Wrap(err, "Failed to open a channel")
 	}
@@ -48,23 +265,44 @@ func (a *AmqpClient) DoOnChannel(chCallback func(channel *amqp.Channel) error) e
 
 //Receive connects to queue and processes each message with given callback
 //Message callback does not result to exit from this method
-func (a *AmqpClient) Receive(ctx context.Context, queue string, autoAck, exclusive, noLocal, noWait bool, msgCallback func(amqp.Delivery) error) error {
+//Up to workers messages are processed concurrently. Once cancelled, waits for in-flight messages to be processed
+//If connection is lost, consuming is resumed once it's restored
+func (a *AmqpClient) Receive(ctx context.Context, queue string, workers int, autoAck, exclusive, noLocal, noWait bool, msgCallback func(amqp.Delivery) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	msgCallback = instrumentDelivery(queue, msgCallback)
+	defer a.setConsumerState(queue, workers, ConsumerStopped, nil)
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
-			if err := a.consumeQueue(ctx, queue, autoAck, exclusive, noLocal, noWait, msgCallback); nil != err {
+			a.setConsumerState(queue, workers, ConsumerWaiting, nil)
+			if err := a.awaitConnection(ctx); nil != err {
 				return err
 			}
+			if err := a.consumeQueue(ctx, queue, workers, autoAck, exclusive, noLocal, noWait, msgCallback); nil != err {
+				if nil != ctx.Err() {
+					return ctx.Err()
+				}
+				a.setConsumerState(queue, workers, ConsumerInterrupted, err)
+				log.Warnf("Consumer of '%s' queue has been interrupted: %v", queue, err)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(a.reconnectBackoff):
+				}
+			}
 		}
 	}
 
 }
 
-func (a *AmqpClient) consumeQueue(ctx context.Context, queue string, autoAck, exclusive, noLocal, noWait bool, msgCallback func(amqp.Delivery) error) error {
+func (a *AmqpClient) consumeQueue(ctx context.Context, queue string, workers int, autoAck, exclusive, noLocal, noWait bool, msgCallback func(amqp.Delivery) error) error {
 	return a.DoOnChannel(func(ch *amqp.Channel) error {
-		qos := ch.Qos(1, 0, false)
+		//broker doesn't deliver more messages than there are free workers
+		qos := ch.Qos(workers, 0, false)
 		if qos != nil {
 			return errors.Wrapf(qos, "Failed to configure Qos")
 		}
@@ -81,8 +319,9 @@ func (a *AmqpClient) consumeQueue(ctx context.Context, queue string, autoAck, ex
 		if cErr != nil {
 			return errors.Wrap(cErr, "Failed to register a consumer")
 		}
+		a.setConsumerState(queue, workers, ConsumerConsuming, nil)
 
-		if err := a.processMessages(ctx, msgs, msgCallback); nil != err {
+		if err := a.processMessages(ctx, msgs, workers, autoAck, msgCallback); nil != err {
 			return err
 		}
 		return nil
@@ -90,19 +329,40 @@ func (a *AmqpClient) consumeQueue(ctx context.Context, queue string, autoAck, ex
 
 }
 
-func (a *AmqpClient) processMessages(ctx context.Context, msgs <-chan amqp.Delivery, msgCallback func(amqp.Delivery) error) error {
+func (a *AmqpClient) processMessages(ctx context.Context, msgs <-chan amqp.Delivery, workers int, autoAck bool, msgCallback func(amqp.Delivery) error) error {
+	slots := make(chan struct{}, workers)
+	var inFlight sync.WaitGroup
+	//channel must not be closed until in-flight messages are acknowledged
+	defer inFlight.Wait()
+
 	for {
+		//wait for free worker before grabbing next message
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
 		//grab incoming messages
 		select {
 		case msg, ok := <-msgs:
 			if !ok {
 				return nil
 			}
+			inFlight.Add(1)
 			go func() {
+				defer func() {
+					<-slots
+					inFlight.Done()
+				}()
 				//process incoming message
-				if err := msgCallback(msg); nil != err {
+				err := msgCallback(msg)
+				if nil != err {
 					log.Error(err)
 				}
+				if !autoAck {
+					a.settle(msg, err)
+				}
 			}()
 			//if cancel signal has been received
 		case <-ctx.Done():
@@ -110,3 +370,102 @@ func (a *AmqpClient) processMessages(ctx context.Context, msgs <-chan amqp.Deliv
 		}
 	}
 }
+
+//settle acknowledges processed message. Failed message is published once again with a delay
+//until max retries count is reached. Message cancelled on shutdown is requeued to the durable queue it's been consumed from.
+//Poison messages and messages out of retries are rejected so broker routes them to the dead-letter exchange
+func (a *AmqpClient) settle(d amqp.Delivery, err error) {
+	if nil == err {
+		if aErr := d.Ack(false); nil != aErr {
+			log.Errorf("Unable to ack message: %v", aErr)
+		}
+		return
+	}
+
+	//message abandoned on shutdown is returned to the queue as is. Queue is durable and shared by the analyzers
+	//so message is delivered to another consumer or to this one after restart without spending a retry
+	if isCancelled(err) {
+		if nErr := d.Nack(false, true); nil != nErr {
+			log.Errorf("Unable to requeue message: %v", nErr)
+		}
+		return
+	}
+
+	retries := retryCount(d)
+	if !a.willRetry(d, err) {
+		log.Warnf("Message from '%s' is dead-lettered after %d retries", d.RoutingKey, retries)
+		if nErr := d.Nack(false, false); nil != nErr {
+			log.Errorf("Unable to reject message: %v", nErr)
+		}
+		return
+	}
+
+	if pErr := a.republish(d, retries+1); nil != pErr {
+		log.Errorf("Unable to republish message: %v", pErr)
+		if nErr := d.Nack(false, true); nil != nErr {
+			log.Errorf("Unable to requeue message: %v", nErr)
+		}
+		return
+	}
+	if aErr := d.Ack(false); nil != aErr {
+		log.Errorf("Unable to ack message: %v", aErr)
+	}
+}
+
+//willRetry checks whether failed message is going to be processed once again
+func (a *AmqpClient) willRetry(d amqp.Delivery, err error) bool {
+	return isCancelled(err) || (!isPoison(err) && retryCount(d) < a.maxRetries)
+}
+
+//republish publishes copy of the message with updated retry count to the retry exchange.
+//Message waits there for retry delay and is routed back to the queue it's been consumed from
+func (a *AmqpClient) republish(d amqp.Delivery, retries int) error {
+	return a.DoOnChannel(func(ch *amqp.Channel) error {
+		return ch.Publish(
+			a.retryExchange, // exchange
+			d.RoutingKey,    // routing key
+			false,           // mandatory
+			false,           // immediate
+			retryPublishing(d, retries))
+	})
+}
+
+//retryPublishing copies properties of the delivery with updated retry count. User ID is not copied
+//since broker rejects messages published on behalf of other user
+func retryPublishing(d amqp.Delivery, retries int) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(retries)
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+//retryCount obtains number of processing attempts from message headers
+func retryCount(d amqp.Delivery) int {
+	switch v := d.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
@@ -16,31 +16,120 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
-	"gopkg.in/go-playground/validator.v9"
+	"net/http"
 )
 
-var validate = validator.New()
+//poisonError marks message which cannot be processed regardless of number of attempts
+type poisonError struct {
+	error
+}
 
-func handleAmqpRequest(ch *amqp.Channel, d amqp.Delivery, handler requestHandler) (err error) {
+//Cause returns the underlying error
+func (e poisonError) Cause() error {
+	return e.error
+}
 
-	var launches []Launch
-	err = json.Unmarshal(d.Body, &launches)
-	if err != nil {
-		err = errors.WithStack(err)
-		return
+//poison marks error as non-retryable
+func poison(err error) error {
+	return poisonError{err}
+}
+
+//isPoison checks whether message caused the error must not be retried
+func isPoison(err error) bool {
+	for err != nil {
+		if _, ok := err.(poisonError); ok {
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
 	}
+	return false
+}
+
+//isCancelled checks whether processing has been abandoned because of shutdown. Such message is neither
+//failed nor retried since it's requeued to the durable queue and processed by another consumer or after restart
+func isCancelled(err error) bool {
+	return nil != err && context.Canceled == errors.Cause(err)
+}
+
+//ErrorReply is sent to RPC caller if request cannot be processed
+type ErrorReply struct {
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	LaunchIndex *int   `json:"launchIndex,omitempty"`
+}
 
-	for i, l := range launches {
-		if err = validate.Struct(l); nil != err {
-			err = errors.Wrapf(err, "Validation failed on Launch[%d]", i)
-			return
+//errorReplyHeader marks reply as ErrorReply
+const errorReplyHeader = "x-error"
+
+//newErrorReply converts processing error to reply
+func newErrorReply(err error) *ErrorReply {
+	rs := &ErrorReply{Code: http.StatusInternalServerError, Message: err.Error()}
+	switch cause := errors.Cause(err).(type) {
+	case *launchValidationError:
+		rs.Code = http.StatusBadRequest
+		rs.LaunchIndex = &cause.index
+	case *ESError:
+		rs.Code = http.StatusBadGateway
+	default:
+		if ErrCircuitOpen == cause {
+			rs.Code = http.StatusServiceUnavailable
+		} else if context.DeadlineExceeded == cause {
+			rs.Code = http.StatusGatewayTimeout
+		} else if isPoison(err) {
+			rs.Code = http.StatusBadRequest
 		}
 	}
+	return rs
+}
+
+//replyOnFailure sends error reply to RPC caller if message processing has failed and message is not going to be retried
+func replyOnFailure(client *AmqpClient, ch *amqp.Channel, d amqp.Delivery, err error) error {
+	if nil == err || "" == d.ReplyTo || client.willRetry(d, err) {
+		return err
+	}
+
+	rsBody, mErr := json.Marshal(newErrorReply(err))
+	if mErr != nil {
+		log.Errorf("Unable to marshal error reply: %v", mErr)
+		return err
+	}
+
+	pErr := ch.Publish(
+		"",        // exchange
+		d.ReplyTo, // routing key
+		false,     // mandatory
+		false,     // immediate
+		amqp.Publishing{
+			Headers:       traceHeaders(deliveryContext(context.Background(), d), amqp.Table{errorReplyHeader: true}),
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Body:          rsBody,
+		})
+	if pErr != nil {
+		log.Errorf("Unable to publish error reply: %v", pErr)
+	}
+	return err
+}
+
+func handleAmqpRequest(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, handler requestHandler) (err error) {
+	ctx, span := startDeliverySpan(ctx, d)
+	defer func() { endSpan(span, err) }()
+
+	launches, err := parseLaunches(d.Body)
+	if err != nil {
+		err = poison(err)
+		return
+	}
 
-	rs, err := handler(launches)
+	rs, err := handler(ctx, launches)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -56,6 +145,7 @@ func handleAmqpRequest(ch *amqp.Channel, d amqp.Delivery, handler requestHandler
 		false,     // mandatory
 		false,     // immediate
 		amqp.Publishing{
+			Headers:       traceHeaders(ctx, nil),
 			ContentType:   "application/json",
 			CorrelationId: d.CorrelationId,
 			Body:          rsBody,
@@ -63,16 +153,17 @@ func handleAmqpRequest(ch *amqp.Channel, d amqp.Delivery, handler requestHandler
 	return err
 }
 
-func handleSearchRequest(ch *amqp.Channel, d amqp.Delivery, h searchRequestHandler) (err error) {
+func handleSearchRequest(ctx context.Context, ch *amqp.Channel, d amqp.Delivery, h searchRequestHandler) (err error) {
+	ctx, span := startDeliverySpan(ctx, d)
+	defer func() { endSpan(span, err) }()
 
-	var request SearchLogs
-	err = json.Unmarshal(d.Body, &request)
+	request, err := parseSearchLogs(d.Body)
 	if err != nil {
-		err = errors.WithStack(err)
+		err = poison(err)
 		return
 	}
 
-	response, err := h(request)
+	response, err := h(ctx, request)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
@@ -90,6 +181,7 @@ func handleSearchRequest(ch *amqp.Channel, d amqp.Delivery, h searchRequestHandl
 		false,     // mandatory
 		false,     // immediate
 		amqp.Publishing{
+			Headers:       traceHeaders(ctx, nil),
 			ContentType:   "application/json",
 			CorrelationId: d.CorrelationId,
 			Body:          rsBody,
@@ -97,17 +189,17 @@ func handleSearchRequest(ch *amqp.Channel, d amqp.Delivery, h searchRequestHandl
 	return err
 }
 
-func handleDeleteRequest(d amqp.Delivery, h *RequestHandler) (err error) {
-
-	var id int64
-	err = json.Unmarshal(d.Body, &id)
+func handleDeleteRequest(ctx context.Context, d amqp.Delivery, h *RequestHandler) (err error) {
+	ctx, span := startDeliverySpan(ctx, d)
+	defer func() { endSpan(span, err) }()
 
+	id, err := parseProjectID(d.Body)
 	if err != nil {
-		err = errors.WithStack(err)
+		err = poison(err)
 		return
 	}
 
-	_, err = h.DeleteIndex(id)
+	_, err = h.DeleteIndex(ctx, id)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
@@ -115,21 +207,17 @@ func handleDeleteRequest(d amqp.Delivery, h *RequestHandler) (err error) {
 	return nil
 }
 
-func handleCleanRequest(d amqp.Delivery, h *RequestHandler) (err error) {
+func handleCleanRequest(ctx context.Context, d amqp.Delivery, h *RequestHandler) (err error) {
+	ctx, span := startDeliverySpan(ctx, d)
+	defer func() { endSpan(span, err) }()
 
-	var ci CleanIndex
-	err = json.Unmarshal(d.Body, &ci)
+	ci, err := parseCleanIndex(d.Body)
 	if err != nil {
-		err = errors.WithStack(err)
-		return
-	}
-
-	if err = validate.Struct(ci); nil != err {
-		err = errors.Wrapf(err, "Validation failed on CleanIndex")
+		err = poison(err)
 		return
 	}
 
-	_, err = h.CleanIndex(&ci)
+	_, err = h.CleanIndex(ctx, ci)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
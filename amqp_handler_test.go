@@ -0,0 +1,79 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestNewErrorReply(t *testing.T) {
+	_, validationErr := parseLaunches([]byte(`[{"launchId":1,"project":1},{"launchName":"no-id"}]`))
+	_, jsonErr := parseLaunches([]byte(`not a json`))
+
+	tests := []struct {
+		name        string
+		err         error
+		code        int
+		launchIndex *int
+	}{
+		{
+			name:        "validation",
+			err:         poison(validationErr),
+			code:        http.StatusBadRequest,
+			launchIndex: new(int),
+		},
+		{
+			name: "malformed json",
+			err:  poison(jsonErr),
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "es error",
+			err:  errors.WithStack(newESError(http.StatusBadRequest, []byte(getFixture(IndexAlreadyExistsRs)))),
+			code: http.StatusBadGateway,
+		},
+		{
+			name: "es unavailable",
+			err:  errors.Wrap(ErrCircuitOpen, "cannot analyze"),
+			code: http.StatusServiceUnavailable,
+		},
+		{
+			name: "es timeout",
+			err:  errors.Wrap(context.DeadlineExceeded, "cannot analyze"),
+			code: http.StatusGatewayTimeout,
+		},
+		{
+			name: "internal",
+			err:  errors.New("unexpected"),
+			code: http.StatusInternalServerError,
+		},
+	}
+	*tests[0].launchIndex = 1
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rs := newErrorReply(tt.err)
+			assert.Equal(t, tt.code, rs.Code)
+			assert.Equal(t, tt.launchIndex, rs.LaunchIndex)
+			assert.NotEmpty(t, rs.Message)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+//recordingAcknowledger records acknowledgements instead of sending them to broker
+type recordingAcknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked = true
+	a.requeue = requeue
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		headers amqp.Table
+		acked   bool
+		nacked  bool
+		requeue bool
+	}{
+		{
+			name:  "processed",
+			acked: true,
+		},
+		{
+			name:   "poison message",
+			err:    errors.WithStack(poison(errors.New("cannot unmarshal"))),
+			nacked: true,
+		},
+		{
+			name:    "out of retries",
+			err:     errors.New("ES is down"),
+			headers: amqp.Table{retryCountHeader: int32(3)},
+			nacked:  true,
+		},
+		{
+			//requeued to the durable queue regardless of retries since processing has not failed
+			name:    "cancelled on shutdown",
+			err:     errors.WithStack(context.Canceled),
+			headers: amqp.Table{retryCountHeader: int32(3)},
+			nacked:  true,
+			requeue: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			c := &AmqpClient{maxRetries: 3}
+			c.settle(amqp.Delivery{Acknowledger: ack, Headers: tt.headers}, tt.err)
+
+			assert.Equal(t, tt.acked, ack.acked)
+			assert.Equal(t, tt.nacked, ack.nacked)
+			assert.Equal(t, tt.requeue, ack.requeue)
+		})
+	}
+}
+
+func Test_retryPublishing(t *testing.T) {
+	now := time.Now()
+	d := amqp.Delivery{
+		Headers:       amqp.Table{"traceparent": "00-trace", retryCountHeader: int32(1)},
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      5,
+		CorrelationId: "correlation",
+		ReplyTo:       "reply",
+		Expiration:    "60000",
+		MessageId:     "message",
+		Timestamp:     now,
+		Type:          "analyze",
+		UserId:        "rabbitmq",
+		AppId:         "api",
+		Body:          []byte("{}"),
+	}
+
+	p := retryPublishing(d, 2)
+	assert.Equal(t, amqp.Table{"traceparent": "00-trace", retryCountHeader: int32(2)}, p.Headers)
+	assert.Equal(t, amqp.Publishing{
+		Headers:       p.Headers,
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      5,
+		CorrelationId: "correlation",
+		ReplyTo:       "reply",
+		Expiration:    "60000",
+		MessageId:     "message",
+		Timestamp:     now,
+		Type:          "analyze",
+		AppId:         "api",
+		Body:          []byte("{}"),
+	}, p)
+	//headers of the delivery are not modified
+	assert.Equal(t, int32(1), d.Headers[retryCountHeader])
+}
+
+func Test_retryCount(t *testing.T) {
+	assert.Equal(t, 0, retryCount(amqp.Delivery{}))
+	assert.Equal(t, 2, retryCount(amqp.Delivery{Headers: amqp.Table{retryCountHeader: int32(2)}}))
+	assert.Equal(t, 5, retryCount(amqp.Delivery{Headers: amqp.Table{retryCountHeader: int64(5)}}))
+}
+
+func Test_isPoison(t *testing.T) {
+	assert.True(t, isPoison(errors.Wrap(poison(errors.New("invalid")), "wrapped")))
+	assert.False(t, isPoison(errors.Wrap(errors.New("ES is down"), "wrapped")))
+	assert.False(t, isPoison(nil))
+}
+
+func TestProcessMessagesIsBounded(t *testing.T) {
+	msgs := make(chan amqp.Delivery)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	running, maxRunning, processed := 0, 0, 0
+	release := make(chan struct{})
+
+	c := &AmqpClient{}
+	done := make(chan error)
+	go func() {
+		done <- c.processMessages(ctx, msgs, 2, true, func(d amqp.Delivery) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			<-release
+
+			mu.Lock()
+			running--
+			processed++
+			mu.Unlock()
+			return nil
+		})
+	}()
+
+	msgs <- amqp.Delivery{}
+	msgs <- amqp.Delivery{}
+
+	//both workers are busy so third message is not grabbed
+	select {
+	case msgs <- amqp.Delivery{}:
+		t.Fatal("Message has been grabbed while all the workers are busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//in-flight messages are processed before exit
+	cancel()
+	close(release)
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 2, maxRunning)
+	assert.Equal(t, 2, processed)
+}
+
+func TestReconnectRetriesUntilCancelled(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	c := NewAmqpClient(func() (*amqp.Connection, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return nil, errors.New("connection refused")
+	}, &AppConfig{AmqpReconnectBackoff: time.Millisecond, AmqpMaxReconnectBackoff: 5 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.False(t, c.reconnect(ctx))
+	mu.Lock()
+	assert.True(t, attempts > 1)
+	mu.Unlock()
+}
+
+func TestAwaitConnection(t *testing.T) {
+	c := NewAmqpClient(nil, &AppConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.awaitConnection(ctx))
+
+	close(c.ready)
+	assert.NoError(t, c.awaitConnection(context.Background()))
+}
@@ -0,0 +1,106 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+//newHTTPClient creates HTTP client trusting configured CA bundle and presenting client certificate if any
+func newHTTPClient(cfg *ClientConfig) (*http.Client, error) {
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       tlsCfg,
+		},
+	}, nil
+}
+
+func newTLSConfig(cfg *ClientConfig) (*tls.Config, error) {
+	//nolint:gosec
+	tlsCfg := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if "" != cfg.CACert {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot read ES CA bundle")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("No certificates found in ES CA bundle %s", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if "" != cfg.ClientCert || "" != cfg.ClientKey {
+		if "" == cfg.ClientCert || "" == cfg.ClientKey {
+			return nil, errors.New("Both ES client certificate and key should be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot load ES client certificate")
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+//validateAuth checks that no more than one authentication method is configured
+func validateAuth(cfg *ClientConfig) error {
+	methods := 0
+	for _, configured := range []bool{"" != cfg.Username, "" != cfg.APIKey, "" != cfg.BearerToken} {
+		if configured {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return errors.New("Only one of ES basic auth, API key or bearer token should be configured")
+	}
+	return nil
+}
+
+//authorize sets credentials of the request
+func authorize(rq *http.Request, cfg *ClientConfig) {
+	switch {
+	case "" != cfg.APIKey:
+		rq.Header.Set("Authorization", "ApiKey "+cfg.APIKey)
+	case "" != cfg.BearerToken:
+		rq.Header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	case "" != cfg.Username:
+		rq.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+}
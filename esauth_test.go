@@ -0,0 +1,174 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      func(cfg *ClientConfig)
+		expected string
+	}{
+		{
+			name:     "no auth",
+			cfg:      func(cfg *ClientConfig) {},
+			expected: "",
+		},
+		{
+			name: "basic",
+			cfg: func(cfg *ClientConfig) {
+				cfg.Username = "elastic"
+				cfg.Password = "changeme"
+			},
+			expected: "Basic ZWxhc3RpYzpjaGFuZ2VtZQ==",
+		},
+		{
+			name: "api key",
+			cfg: func(cfg *ClientConfig) {
+				cfg.APIKey = "VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="
+			},
+			expected: "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==",
+		},
+		{
+			name: "bearer",
+			cfg: func(cfg *ClientConfig) {
+				cfg.BearerToken = "token"
+			},
+			expected: "Bearer token",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var header string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get("Authorization")
+			}))
+			defer ts.Close()
+
+			cfg := defaultClientConfig()
+			tt.cfg(cfg)
+			c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+			exists, err := c.IndexExists("idx0")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, tt.expected, header)
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "es-tls")
+	assert.NoError(t, err)
+	defer func() {
+		if rErr := os.RemoveAll(dir); nil != rErr {
+			log.Error(rErr)
+		}
+	}()
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	tests := []struct {
+		name      string
+		cfg       func(cfg *ClientConfig)
+		expectErr bool
+	}{
+		{
+			name:      "unknown authority",
+			cfg:       func(cfg *ClientConfig) {},
+			expectErr: true,
+		},
+		{
+			name: "custom CA bundle",
+			cfg: func(cfg *ClientConfig) {
+				cfg.CACert = caFile
+			},
+		},
+		{
+			name: "insecure skip verify",
+			cfg: func(cfg *ClientConfig) {
+				cfg.InsecureSkipVerify = true
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultClientConfig()
+			cfg.MaxRetries = 0
+			tt.cfg(cfg)
+			c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+			_, err := c.IndexExists("idx0")
+			assert.Equal(t, tt.expectErr, nil != err)
+		})
+	}
+}
+
+func TestNewClientFailsOnInsecureConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(cfg *ClientConfig)
+	}{
+		{
+			name: "several auth methods",
+			cfg: func(cfg *ClientConfig) {
+				cfg.Username = "elastic"
+				cfg.BearerToken = "token"
+			},
+		},
+		{
+			name: "client cert without key",
+			cfg: func(cfg *ClientConfig) {
+				cfg.ClientCert = "client.pem"
+			},
+		},
+		{
+			name: "not existing CA bundle",
+			cfg: func(cfg *ClientConfig) {
+				cfg.CACert = "not-existing.pem"
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultClientConfig()
+			tt.cfg(cfg)
+			//client must not silently fall back to plain HTTP client
+			c, err := NewClient([]string{"https://localhost:9200"}, cfg, defaultSearchConfig())
+			assert.Error(t, err)
+			assert.Nil(t, c)
+		})
+	}
+
+	_, err := NewClient([]string{"https://localhost:9200"}, defaultClientConfig(), defaultSearchConfig())
+	assert.NoError(t, err)
+}
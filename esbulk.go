@@ -0,0 +1,352 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Refresh policies of bulk requests
+const (
+	RefreshTrue    = "true"
+	RefreshWaitFor = "wait_for"
+	RefreshFalse   = "false"
+)
+
+//IndexSummary summarises results of index request
+type IndexSummary struct {
+	Indexed        int `json:"indexed"`
+	SkippedByLevel int `json:"skippedByLevel"`
+	Failed         int `json:"failed"`
+}
+
+//BulkItemError is a reason bulk operation has been rejected by ES
+type BulkItemError struct {
+	Type     string      `json:"type,omitempty"`
+	Reason   string      `json:"reason,omitempty"`
+	CausedBy *ErrorCause `json:"caused_by,omitempty"`
+}
+
+func (e *BulkItemError) String() string {
+	if nil == e {
+		return "unknown error"
+	}
+	s := "[" + e.Type + "] " + e.Reason
+	if nil != e.CausedBy {
+		s += " caused by [" + e.CausedBy.Type + "] " + e.CausedBy.Reason
+	}
+	return s
+}
+
+//bulkDoc is a log document indexed within bulk request
+type bulkDoc struct {
+	logID    int64
+	testItem int64
+	op       json.RawMessage
+	body     json.RawMessage
+}
+
+//newBulkDoc encodes operation and source of the document in advance so size of the document is known
+func newBulkDoc(logID, testItem int64, op, body interface{}) (bulkDoc, error) {
+	d := bulkDoc{logID: logID, testItem: testItem}
+	var err error
+	if d.op, err = json.Marshal(op); nil != err {
+		return d, errors.Wrapf(err, "Cannot encode log %d", logID)
+	}
+	if d.body, err = json.Marshal(body); nil != err {
+		return d, errors.Wrapf(err, "Cannot encode log %d", logID)
+	}
+	return d, nil
+}
+
+//size is a number of bytes the document takes within bulk request including new lines
+func (d bulkDoc) size() int {
+	return len(d.op) + len(d.body) + 2
+}
+
+//validateRefresh checks refresh policy so misconfiguration is reported on startup
+func validateRefresh(refresh string) error {
+	switch refresh {
+	case RefreshTrue, RefreshWaitFor, RefreshFalse:
+		return nil
+	}
+	return errors.Errorf("Unknown ES refresh policy: %s. Should be one of %s, %s or %s",
+		refresh, RefreshTrue, RefreshWaitFor, RefreshFalse)
+}
+
+//refreshParam builds query parameter of bulk request telling ES when changes are made visible to search
+func refreshParam(refresh string) string {
+	switch refresh {
+	case RefreshFalse:
+		return ""
+	case RefreshWaitFor:
+		return "?refresh=" + RefreshWaitFor
+	default:
+		return "?refresh"
+	}
+}
+
+//bulkIndexer indexes documents within bulk requests limited by count and size in bytes. Chunk is sent as soon
+//as it's full so documents are not kept in memory once they are sent. Up to concurrency chunks are sent at once.
+//Rest of the chunks are not sent once any of them fails
+type bulkIndexer struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	send     func(ctx context.Context, docs []bulkDoc) (*BulkResponse, error)
+	maxDocs  int
+	maxBytes int
+	slots    chan struct{}
+	wg       sync.WaitGroup
+
+	chunk []bulkDoc
+	size  int
+
+	mu      sync.Mutex
+	results []*BulkResponse
+	err     error
+}
+
+//newBulkIndexer creates indexer sending chunks of documents by provided function. Zero or negative limit means no limit
+func newBulkIndexer(ctx context.Context, maxDocs, maxBytes, concurrency int,
+	send func(ctx context.Context, docs []bulkDoc) (*BulkResponse, error)) *bulkIndexer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &bulkIndexer{
+		ctx:      ctx,
+		cancel:   cancel,
+		send:     send,
+		maxDocs:  maxDocs,
+		maxBytes: maxBytes,
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+//add appends document to the current chunk. Chunk is sent once the document does not fit into it
+//so document exceeding size limit is sent within a separate chunk. Fails once indexing is aborted
+func (b *bulkIndexer) add(d bulkDoc) error {
+	full := (b.maxDocs > 0 && len(b.chunk) >= b.maxDocs) || (b.maxBytes > 0 && b.size+d.size() > b.maxBytes)
+	if full && len(b.chunk) > 0 {
+		if err := b.flush(); nil != err {
+			return err
+		}
+	}
+	b.chunk = append(b.chunk, d)
+	b.size += d.size()
+	return nil
+}
+
+//flush sends the current chunk as soon as there is a free slot
+func (b *bulkIndexer) flush() error {
+	if len(b.chunk) == 0 {
+		return nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+	case <-b.ctx.Done():
+		return errors.WithStack(b.ctx.Err())
+	}
+	//slot may be freed by the chunk which has failed
+	if err := b.ctx.Err(); nil != err {
+		<-b.slots
+		return errors.WithStack(err)
+	}
+	chunk := b.chunk
+	b.chunk, b.size = nil, 0
+
+	b.mu.Lock()
+	i := len(b.results)
+	b.results = append(b.results, nil)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer func() {
+			<-b.slots
+			b.wg.Done()
+		}()
+		rs, err := b.send(b.ctx, chunk)
+		if nil == err {
+			rs.Summary = summarize(chunk, rs)
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if nil != err {
+			//failure of the chunk is reported rather than cancellation of the others it has caused
+			if nil == b.err || isCancelled(b.err) && !isCancelled(err) {
+				b.err = err
+			}
+			b.cancel()
+			return
+		}
+		b.results[i] = rs
+	}()
+	return nil
+}
+
+//close sends the rest of the documents and waits for all the chunks to be indexed.
+//Items of response are in the same order as documents
+func (b *bulkIndexer) close() (*BulkResponse, error) {
+	err := b.flush()
+	b.wg.Wait()
+	b.cancel()
+	if nil != b.err {
+		return nil, b.err
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	rs := &BulkResponse{Summary: &IndexSummary{}}
+	for _, r := range b.results {
+		rs.Took += r.Took
+		rs.Items = append(rs.Items, r.Items...)
+		rs.Summary.Indexed += r.Summary.Indexed
+		rs.Summary.Failed += r.Summary.Failed
+	}
+	rs.Errors = rs.Summary.Failed > 0
+	log.Debugf("%d logs have been sent within %d bulk requests", len(rs.Items), len(b.results))
+	return rs, nil
+}
+
+//abort cancels chunks in flight and waits for them to be stopped. Returns failure of the chunk if any
+func (b *bulkIndexer) abort() error {
+	b.cancel()
+	b.wg.Wait()
+	return b.err
+}
+
+//ErrIndexBlocked means logs have been rejected since index is blocked for writes while it's migrated.
+//Request should be repeated once migration is completed
+var ErrIndexBlocked = errors.New("Index is blocked for writes")
+
+//isBlockedItem checks whether bulk operation has been rejected by write block of the index
+func isBlockedItem(e *BulkItemError) bool {
+	return nil != e && "cluster_block_exception" == e.Type
+}
+
+//bulkDeleteResponse is a result of bulk delete request
+type bulkDeleteResponse struct {
+	Items []struct {
+		Delete struct {
+			ID     string         `json:"_id,omitempty"`
+			Status int            `json:"status,omitempty"`
+			Error  *BulkItemError `json:"error,omitempty"`
+		} `json:"delete"`
+	} `json:"items,omitempty"`
+}
+
+//isRetryableItem checks whether bulk operation has been rejected because ES is overloaded
+func isRetryableItem(status int) bool {
+	return http.StatusTooManyRequests == status
+}
+
+//missingItem is a failure of the operation ES has not reported result of
+func missingItem(d bulkDoc) BulkItem {
+	item := BulkItem{}
+	item.Index.ID = strconv.FormatInt(d.logID, 10)
+	item.Index.Error = &BulkItemError{Type: "missing_item", Reason: "Result of the operation has not been reported by ES"}
+	return item
+}
+
+//bulkIndex indexes documents within bulk request. Items rejected with retryable status are sent once again
+//with exponential backoff until max retries count is reached. Items of response are in the same order as documents.
+//Operations ES has not reported result of are failed. Failures are observed once items are out of retries
+func (c *client) bulkIndex(ctx context.Context, url string, docs []bulkDoc) (*BulkResponse, error) {
+	items := make([]*BulkItem, len(docs))
+	rs := &BulkResponse{}
+
+	pending := make([]int, len(docs))
+	for i := range docs {
+		pending[i] = i
+	}
+	for attempt := 0; ; attempt++ {
+		bodies := make([]interface{}, 0, 2*len(pending))
+		for _, i := range pending {
+			bodies = append(bodies, docs[i].op, docs[i].body)
+		}
+
+		brs := &BulkResponse{}
+		if err := c.sendOpRequest(ctx, http.MethodPut, url, brs, bodies...); err != nil {
+			return nil, err
+		}
+		rs.Took += brs.Took
+		if len(brs.Items) < len(pending) {
+			log.Errorf("ES has reported results of %d of %d bulk items", len(brs.Items), len(pending))
+		}
+
+		var retry []int
+		for j, i := range pending {
+			if j >= len(brs.Items) {
+				item := missingItem(docs[i])
+				items[i] = &item
+				continue
+			}
+			items[i] = &brs.Items[j]
+			if isRetryableItem(brs.Items[j].Index.Status) {
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || attempt >= c.clientCfg.MaxRetries {
+			break
+		}
+
+		delay := backoff(attempt, c.clientCfg.RetryBackoff, c.clientCfg.MaxRetryBackoff)
+		log.Warnf("%d of %d bulk items have been rejected by ES. Retrying in %v", len(retry), len(pending), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		}
+		pending = retry
+	}
+
+	for _, item := range items {
+		rs.Items = append(rs.Items, *item)
+	}
+	observeBulkItems(rs)
+	return rs, nil
+}
+
+//summarize counts indexed and failed items of bulk response. Each failed item is logged along with its log and test item
+func summarize(docs []bulkDoc, rs *BulkResponse) *IndexSummary {
+	testItems := make(map[string]int64, len(docs))
+	for _, d := range docs {
+		testItems[strconv.FormatInt(d.logID, 10)] = d.testItem
+	}
+
+	summary := &IndexSummary{}
+	for _, item := range rs.Items {
+		status := item.Index.Status
+		if status >= http.StatusOK && status < http.StatusMultipleChoices {
+			summary.Indexed++
+			continue
+		}
+		summary.Failed++
+		log.Errorf("Log %s of test item %d has not been indexed. Status %d: %s",
+			item.Index.ID, testItems[item.Index.ID], status, item.Index.Error)
+	}
+	rs.Errors = summary.Failed > 0
+	return summary
+}
@@ -0,0 +1,405 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	rejectedItemError = `{"type":"es_rejected_execution_exception","reason":"rejected execution of bulk"}`
+	invalidItemError  = `{"type":"mapper_parsing_exception","reason":"failed to parse field [log_time]",` +
+		`"caused_by":{"type":"illegal_argument_exception","reason":"invalid date"}}`
+)
+
+func bulkItemRs(id string, status int, err string) string {
+	item := `"_index":"2_v2","_id":"` + id + `","status":` + strconv.Itoa(status)
+	if "" != err {
+		item += `,"error":` + err
+	}
+	return `{"index":{` + item + `}}`
+}
+
+func bulkRs(errors bool, items ...string) string {
+	return `{"took":5,"errors":` + strconv.FormatBool(errors) + `,"items":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestIndexLogsReportsItems(t *testing.T) {
+	//second document of index_logs_rq.json
+	secondDoc := strings.Join(strings.SplitAfter(getFixture(IndexLogsRq), "\n")[2:], "")
+
+	tests := []struct {
+		name     string
+		calls    []ServerCall
+		indexRq  string
+		summary  IndexSummary
+		errors   bool
+		failedID string
+		reason   string
+		//failures are increments of failed items metric by status
+		failures map[string]float64
+	}{
+		{
+			name: "rejected item is retried",
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     getFixture(IndexLogsRq),
+					rs:     bulkRs(true, bulkItemRs("1", http.StatusCreated, ""), bulkItemRs("2", http.StatusTooManyRequests, rejectedItemError)),
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     secondDoc,
+					rs:     bulkRs(false, bulkItemRs("2", http.StatusCreated, "")),
+					status: http.StatusOK,
+				},
+			},
+			indexRq: getFixture(LaunchWTestItemsWLogs),
+			summary: IndexSummary{Indexed: 2},
+		},
+		{
+			name: "rejected item is out of retries",
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     getFixture(IndexLogsRq),
+					rs:     bulkRs(true, bulkItemRs("1", http.StatusCreated, ""), bulkItemRs("2", http.StatusTooManyRequests, rejectedItemError)),
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     secondDoc,
+					rs:     bulkRs(true, bulkItemRs("2", http.StatusTooManyRequests, rejectedItemError)),
+					status: http.StatusOK,
+				},
+			},
+			indexRq:  getFixture(LaunchWTestItemsWLogs),
+			summary:  IndexSummary{Indexed: 1, Failed: 1},
+			errors:   true,
+			failedID: "2",
+			reason:   "rejected execution of bulk",
+			failures: map[string]float64{"429": 1},
+		},
+		{
+			name: "invalid item is not retried",
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     getFixture(IndexLogsRq),
+					rs:     bulkRs(true, bulkItemRs("1", http.StatusBadRequest, invalidItemError), bulkItemRs("2", http.StatusCreated, "")),
+					status: http.StatusOK,
+				},
+			},
+			indexRq:  getFixture(LaunchWTestItemsWLogs),
+			summary:  IndexSummary{Indexed: 1, Failed: 1},
+			errors:   true,
+			failedID: "1",
+			reason:   "failed to parse field [log_time]",
+			failures: map[string]float64{"400": 1},
+		},
+		{
+			name: "item is not reported by ES",
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     getFixture(IndexLogsRq),
+					rs:     bulkRs(false, bulkItemRs("1", http.StatusCreated, "")),
+					status: http.StatusOK,
+				},
+			},
+			indexRq:  getFixture(LaunchWTestItemsWLogs),
+			summary:  IndexSummary{Indexed: 1, Failed: 1},
+			errors:   true,
+			failedID: "2",
+			reason:   "Result of the operation has not been reported by ES",
+			failures: map[string]float64{"missing": 1},
+		},
+		{
+			name: "logs below error level are skipped",
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rq:     getFixture(IndexLogsRqDifferentLogLevel),
+					rs:     bulkRs(false, bulkItemRs("1", http.StatusCreated, "")),
+					status: http.StatusOK,
+				},
+			},
+			indexRq: getFixture(LaunchWTestItemsWLogsDifferentLogLevel),
+			summary: IndexSummary{Indexed: 1, SkippedByLevel: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			calls := append([]ServerCall{{method: "HEAD", uri: "/2", status: http.StatusOK}}, tt.calls...)
+			i := 0
+			ts := startServer(t, calls, &i)
+			defer ts.Close()
+
+			cfg := defaultClientConfig()
+			cfg.MaxRetries = 1
+			cfg.RetryBackoff = time.Millisecond
+			c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+			//items rejected by the attempts followed by retry are not failed
+			before := map[string]float64{}
+			for _, status := range []string{"429", "400", "missing"} {
+				before[status] = testutil.ToFloat64(bulkItemFailures.WithLabelValues(status))
+			}
+
+			rs, err := c.IndexLogs(parseLaunchesFixture(t, tt.indexRq))
+			assert.NoError(t, err)
+			assert.Equal(t, len(calls), i)
+			for status, v := range before {
+				assert.Equal(t, v+tt.failures[status], testutil.ToFloat64(bulkItemFailures.WithLabelValues(status)), status)
+			}
+			if assert.NotNil(t, rs.Summary) {
+				assert.Equal(t, tt.summary, *rs.Summary)
+			}
+			assert.Equal(t, tt.errors, rs.Errors)
+			assert.Len(t, rs.Items, tt.summary.Indexed+tt.summary.Failed)
+			for _, item := range rs.Items {
+				if item.Index.ID == tt.failedID {
+					if assert.NotNil(t, item.Index.Error) {
+						assert.Equal(t, tt.reason, item.Index.Error.Reason)
+					}
+				} else {
+					assert.Nil(t, item.Index.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkItemErrorString(t *testing.T) {
+	var e *BulkItemError
+	assert.Equal(t, "unknown error", e.String())
+
+	e = &BulkItemError{Type: "mapper_parsing_exception", Reason: "failed to parse", CausedBy: &ErrorCause{Type: "illegal_argument_exception", Reason: "invalid date"}}
+	assert.Equal(t, "[mapper_parsing_exception] failed to parse caused by [illegal_argument_exception] invalid date", e.String())
+}
+
+func TestIndexLogsChunks(t *testing.T) {
+	lines := strings.SplitAfter(getFixture(IndexLogsRq), "\n")
+	firstDoc := strings.Join(lines[:2], "")
+	secondDoc := strings.Join(lines[2:], "")
+	created := bulkItemRs("1", http.StatusCreated, "")
+
+	tests := []struct {
+		name  string
+		cfg   func(cfg *ClientConfig)
+		calls []ServerCall
+	}{
+		{
+			name: "single bulk without refresh",
+			cfg: func(cfg *ClientConfig) {
+				cfg.Refresh = RefreshFalse
+			},
+			calls: []ServerCall{
+				{method: "PUT", uri: "/_bulk", rq: getFixture(IndexLogsRq), rs: bulkRs(false, created, created), status: http.StatusOK},
+			},
+		},
+		{
+			name: "split by documents count",
+			cfg: func(cfg *ClientConfig) {
+				cfg.BulkSize = 1
+			},
+			calls: []ServerCall{
+				{method: "PUT", uri: "/_bulk?refresh", rq: firstDoc, rs: bulkRs(false, created), status: http.StatusOK},
+				{method: "PUT", uri: "/_bulk?refresh", rq: secondDoc, rs: bulkRs(false, created), status: http.StatusOK},
+			},
+		},
+		{
+			name: "split by size",
+			cfg: func(cfg *ClientConfig) {
+				cfg.BulkSize = 0
+				cfg.BulkBytes = len(firstDoc) + 1
+				cfg.Refresh = RefreshWaitFor
+			},
+			calls: []ServerCall{
+				{method: "PUT", uri: "/_bulk?refresh=wait_for", rq: firstDoc, rs: bulkRs(false, created), status: http.StatusOK},
+				{method: "PUT", uri: "/_bulk?refresh=wait_for", rq: secondDoc, rs: bulkRs(false, created), status: http.StatusOK},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			calls := append([]ServerCall{{method: "HEAD", uri: "/2", status: http.StatusOK}}, tt.calls...)
+			i := 0
+			ts := startServer(t, calls, &i)
+			defer ts.Close()
+
+			cfg := defaultClientConfig()
+			cfg.BulkConcurrency = 1
+			tt.cfg(cfg)
+			c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+			rs, err := c.IndexLogs(parseLaunchesFixture(t, getFixture(LaunchWTestItemsWLogs)))
+			assert.NoError(t, err)
+			assert.Equal(t, len(calls), i)
+			assert.Len(t, rs.Items, 2)
+			assert.Equal(t, IndexSummary{Indexed: 2}, *rs.Summary)
+		})
+	}
+}
+
+func TestBulkConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodHead == r.Method {
+			return
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for m := atomic.LoadInt32(&maxInFlight); n > m && !atomic.CompareAndSwapInt32(&maxInFlight, m, n); {
+			m = atomic.LoadInt32(&maxInFlight)
+		}
+		_, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+		if _, wErr := w.Write([]byte(bulkRs(false, bulkItemRs("1", http.StatusCreated, "")))); wErr != nil {
+			log.Error(wErr)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := defaultClientConfig()
+	cfg.BulkSize = 1
+	cfg.BulkConcurrency = 2
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	ti := TestItem{TestItemID: 1, UniqueID: "unique"}
+	for id := int64(1); id <= 6; id++ {
+		ti.Logs = append(ti.Logs, Log{LogID: id, LogLevel: ErrorLoggingLevel, Message: "java.lang.NullPointerException"})
+	}
+	rs, err := c.IndexLogs([]Launch{{LaunchID: 1, Project: 2, TestItems: []TestItem{ti}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, rs.Summary.Indexed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+}
+
+func Test_bulkIndexer(t *testing.T) {
+	//each document takes 11 bytes
+	docs := make([]bulkDoc, 5)
+	for i := range docs {
+		docs[i] = bulkDoc{logID: int64(i), op: json.RawMessage(`{}`), body: json.RawMessage(`{"a":1}`)}
+	}
+
+	tests := []struct {
+		name     string
+		maxDocs  int
+		maxBytes int
+		expected []int
+	}{
+		{name: "no limits", expected: []int{5}},
+		{name: "documents count", maxDocs: 2, expected: []int{2, 2, 1}},
+		{name: "size", maxBytes: 33, expected: []int{3, 2}},
+		{name: "both limits", maxDocs: 2, maxBytes: 11, expected: []int{1, 1, 1, 1, 1}},
+		{name: "document exceeds size", maxBytes: 5, expected: []int{1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes []int
+			bi := newBulkIndexer(context.Background(), tt.maxDocs, tt.maxBytes, 1, func(ctx context.Context, chunk []bulkDoc) (*BulkResponse, error) {
+				sizes = append(sizes, len(chunk))
+				rs := &BulkResponse{}
+				for _, d := range chunk {
+					rs.Items = append(rs.Items, BulkItem{})
+					rs.Items[len(rs.Items)-1].Index.ID = strconv.FormatInt(d.logID, 10)
+					rs.Items[len(rs.Items)-1].Index.Status = http.StatusCreated
+				}
+				return rs, nil
+			})
+			for _, d := range docs {
+				assert.NoError(t, bi.add(d))
+			}
+			rs, err := bi.close()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, sizes)
+			assert.Equal(t, IndexSummary{Indexed: len(docs)}, *rs.Summary)
+			if assert.Len(t, rs.Items, len(docs)) {
+				for i, item := range rs.Items {
+					assert.Equal(t, strconv.Itoa(i), item.Index.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexLogsStopsOnFailedChunk(t *testing.T) {
+	var bulks int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodHead == r.Method {
+			return
+		}
+		_, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		if atomic.AddInt32(&bulks, 1) == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, wErr := w.Write([]byte(bulkRs(false, bulkItemRs("1", http.StatusCreated, "")))); wErr != nil {
+			log.Error(wErr)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := defaultClientConfig()
+	cfg.BulkSize = 1
+	cfg.BulkConcurrency = 1
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	ti := TestItem{TestItemID: 1, UniqueID: "unique"}
+	for id := int64(1); id <= 6; id++ {
+		ti.Logs = append(ti.Logs, Log{LogID: id, LogLevel: ErrorLoggingLevel, Message: "java.lang.NullPointerException"})
+	}
+	//logs of the first chunk stay indexed and are indexed once again along with the rest of them once request is repeated
+	_, err := c.IndexLogs([]Launch{{LaunchID: 1, Project: 2, TestItems: []TestItem{ti}}})
+	assert.Error(t, err)
+	assert.False(t, isCancelled(err))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&bulks))
+}
+
+func Test_refreshParam(t *testing.T) {
+	assert.Equal(t, "?refresh", refreshParam(RefreshTrue))
+	assert.Equal(t, "?refresh=wait_for", refreshParam(RefreshWaitFor))
+	assert.Equal(t, "", refreshParam(RefreshFalse))
+
+	assert.NoError(t, validateRefresh(RefreshWaitFor))
+	assert.Error(t, validateRefresh("sometimes"))
+}
@@ -16,17 +16,21 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
 	"math"
 	"net/http"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 //ErrorLoggingLevel is integer representation of ERROR logging level
@@ -34,53 +38,57 @@ const ErrorLoggingLevel int = 40000
 
 // ESClient interface
 type ESClient interface {
+	Backend
+
 	ListIndices() ([]Index, error)
 	CreateIndex(name string) (*Response, error)
 	IndexExists(name string) (bool, error)
-	DeleteIndex(name int64) (*Response, error)
-
-	IndexLogs(launches []Launch) (*BulkResponse, error)
-	DeleteLogs(ci *CleanIndex) (*Response, error)
-	AnalyzeLogs(launches []Launch) ([]AnalysisResult, error)
-	SearchLogs(request SearchLogs) ([]int64, error)
-
-	Healthy() bool
-
-	createIndexIfNotExists(indexName string) error
+	MigrateIndex(project int64) error
+	ListProjects() ([]int64, error)
+
+	HealthyContext(ctx context.Context) bool
+	ClusterHealthContext(ctx context.Context) (*ClusterHealth, error)
+	ListIndicesContext(ctx context.Context) ([]Index, error)
+	CreateIndexContext(ctx context.Context, name string) (*Response, error)
+	IndexExistsContext(ctx context.Context, name string) (bool, error)
+	MigrateIndexContext(ctx context.Context, project int64) error
+	ListProjectsContext(ctx context.Context) ([]int64, error)
+
+	createIndexIfNotExists(ctx context.Context, indexName string) error
 	buildURL(pathElements ...string) string
-	sanitizeText(text string) string
+	normalize(message string, lines int) string
 }
 
 // Response struct
 type Response struct {
-	Acknowledged bool `json:"acknowledged,omitempty"`
-	Error        struct {
-		RootCause []struct {
-			Type   string `json:"type,omitempty"`
-			Reason string `json:"reason,omitempty"`
-		} `json:"root_cause,omitempty"`
-		Type   string `json:"type,omitempty"`
-		Reason string `json:"reason,omitempty"`
-	} `json:"error,omitempty"`
-	Status int `json:"status,omitempty"`
+	Acknowledged bool         `json:"acknowledged,omitempty"`
+	Error        ErrorDetails `json:"error,omitempty"`
+	Status       int          `json:"status,omitempty"`
 }
 
 // BulkResponse struct
 type BulkResponse struct {
-	Took   int  `json:"took,omitempty"`
-	Errors bool `json:"errors,omitempty"`
-	Items  []struct {
-		Index struct {
-			Index   string `json:"_index,omitempty"`
-			Type    string `json:"_type,omitempty"`
-			ID      string `json:"_id,omitempty"`
-			Version int    `json:"_version,omitempty"`
-			Result  string `json:"result,omitempty"`
-			Created bool   `json:"created,omitempty"`
-			Status  int    `json:"status,omitempty"`
-		} `json:"index,omitempty"`
-	} `json:"items,omitempty"`
-	Status int `json:"status,omitempty"`
+	Took   int        `json:"took,omitempty"`
+	Errors bool       `json:"errors,omitempty"`
+	Items  []BulkItem `json:"items,omitempty"`
+	Status int        `json:"status,omitempty"`
+	//Summary is filled by the analyzer once logs are indexed
+	Summary *IndexSummary `json:"summary,omitempty"`
+}
+
+// BulkItem is a result of single bulk operation
+type BulkItem struct {
+	Index struct {
+		Index   string `json:"_index,omitempty"`
+		Type    string `json:"_type,omitempty"`
+		ID      string `json:"_id,omitempty"`
+		Version int    `json:"_version,omitempty"`
+		Result  string `json:"result,omitempty"`
+		Created bool   `json:"created,omitempty"`
+		Status  int    `json:"status,omitempty"`
+		//Error is a reason the operation has been rejected
+		Error *BulkItemError `json:"error,omitempty"`
+	} `json:"index,omitempty"`
 }
 
 // Launch struct
@@ -89,18 +97,28 @@ type Launch struct {
 	Project    int64        `json:"project,required" validate:"required"`
 	LaunchName string       `json:"launchName,omitempty"`
 	Conf       AnalyzerConf `json:"analyzerConfig"`
-	TestItems  []struct {
-		TestItemID        int64  `json:"testItemId,required" validate:"required"`
-		UniqueID          string `json:"uniqueId,required" validate:"required"`
-		IsAutoAnalyzed    bool   `json:"isAutoAnalyzed,required" validate:"required"`
-		IssueType         string `json:"issueType,omitempty"`
-		OriginalIssueType string `json:"originalIssueType,omitempty"`
-		Logs              []struct {
-			LogID    int64  `json:"logId,required" validate:"required"`
-			LogLevel int    `json:"logLevel,omitempty"`
-			Message  string `json:"message,required" validate:"required"`
-		} `json:"logs,omitempty"`
-	} `json:"testItems,omitempty"`
+	TestItems  []TestItem   `json:"testItems,omitempty"`
+	//Explain requests explanation of predictions made for the launch. Backend reports test items issue type
+	//has not been predicted for as well with empty issue type. They are replied by explain request only
+	Explain bool `json:"explain,omitempty"`
+}
+
+// TestItem struct
+type TestItem struct {
+	TestItemID        int64  `json:"testItemId,required" validate:"required"`
+	UniqueID          string `json:"uniqueId,required" validate:"required"`
+	IsAutoAnalyzed    bool   `json:"isAutoAnalyzed,required" validate:"required"`
+	IssueType         string `json:"issueType,omitempty"`
+	OriginalIssueType string `json:"originalIssueType,omitempty"`
+	Logs              []Log  `json:"logs,omitempty"`
+}
+
+// Log struct
+type Log struct {
+	LogID    int64      `json:"logId,required" validate:"required"`
+	LogLevel int        `json:"logLevel,omitempty"`
+	Message  string     `json:"message,required" validate:"required"`
+	LogTime  *time.Time `json:"logTime,omitempty"`
 }
 
 // AnalyzerConf struct
@@ -112,6 +130,22 @@ type AnalyzerConf struct {
 	AAEnabled       bool       `json:"isAutoAnalyzerEnabled"`
 	Mode            SearchMode `json:"analyzerMode"`
 	IndexingRunning bool       `json:"indexingRunning"`
+	ScoringStrategy string     `json:"scoringStrategy,omitempty"`
+}
+
+//ClusterHealth is a health status of ES cluster
+type ClusterHealth struct {
+	ClusterName      string `json:"cluster_name,omitempty"`
+	Status           string `json:"status,omitempty"`
+	TimedOut         bool   `json:"timed_out,omitempty"`
+	NumberOfNodes    int    `json:"number_of_nodes,omitempty"`
+	ActiveShards     int    `json:"active_shards,omitempty"`
+	UnassignedShards int    `json:"unassigned_shards,omitempty"`
+}
+
+//Operational returns TRUE if cluster is able to serve requests
+func (h *ClusterHealth) Operational() bool {
+	return "yellow" == h.Status || "green" == h.Status
 }
 
 // Index struct
@@ -139,6 +173,19 @@ type SearchResult struct {
 	} `json:"hits,omitempty"`
 }
 
+// MultiSearchResult struct
+type MultiSearchResult struct {
+	Took      int              `json:"took,omitempty"`
+	Responses []SearchResponse `json:"responses,omitempty"`
+}
+
+// SearchResponse is a single response of multi search
+type SearchResponse struct {
+	SearchResult
+	Error  *ErrorDetails `json:"error,omitempty"`
+	Status int           `json:"status,omitempty"`
+}
+
 // Total struct
 type Total struct {
 	Value    int    `json:"value,omitempty"`
@@ -152,19 +199,34 @@ type Hit struct {
 	ID     string  `json:"_id,omitempty"`
 	Score  float64 `json:"_score,omitempty"`
 	Source struct {
-		TestItem   int64  `json:"test_item,omitempty"`
-		IssueType  string `json:"issue_type,omitempty"`
-		Message    string `json:"message,omitempty"`
-		LogLevel   int    `json:"log_level,omitempty"`
-		LaunchName string `json:"launch_name,omitempty"`
+		TestItem   int64      `json:"test_item,omitempty"`
+		IssueType  string     `json:"issue_type,omitempty"`
+		Message    string     `json:"message,omitempty"`
+		LogLevel   int        `json:"log_level,omitempty"`
+		LaunchName string     `json:"launch_name,omitempty"`
+		LogTime    *time.Time `json:"log_time,omitempty"`
 	} `json:"_source,omitempty"`
+	Explanation *HitExplanation `json:"_explanation,omitempty"`
 }
 
 //AnalysisResult represents result of analyzes which is basically array of found matches (predicted issue type and ID of most relevant Test Item)
+//Score is a confidence of the prediction in range (0, 1]. Alternatives are competing issue types ordered by score
 type AnalysisResult struct {
-	TestItem     int64  `json:"testItem,omitempty"`
-	IssueType    string `json:"issueType,omitempty"`
-	RelevantItem int64  `json:"relevantItem,omitempty"`
+	TestItem     int64            `json:"testItem,omitempty"`
+	IssueType    string           `json:"issueType,omitempty"`
+	RelevantItem int64            `json:"relevantItem,omitempty"`
+	RelevantLog  int64            `json:"relevantLogId,omitempty"`
+	Score        float64          `json:"score,omitempty"`
+	Alternatives []IssueTypeScore `json:"alternatives,omitempty"`
+	Explanation  *Explanation     `json:"explanation,omitempty"`
+}
+
+//IssueTypeScore is a confidence of issue type which has not been predicted
+type IssueTypeScore struct {
+	IssueType    string  `json:"issueType,omitempty"`
+	Score        float64 `json:"score,omitempty"`
+	RelevantItem int64   `json:"relevantItem,omitempty"`
+	RelevantLog  int64   `json:"relevantLogId,omitempty"`
 }
 
 //CleanIndex is a request to clean index
@@ -191,20 +253,36 @@ type SearchLogConfig struct {
 }
 
 type client struct {
-	hosts     []string
-	re        *regexp.Regexp
-	hc        *http.Client
-	searchCfg *SearchConfig
+	hosts      *hostPool
+	breaker    *circuitBreaker
+	normalizer *Normalizer
+	strategy   ScoringStrategy
+	hc         *http.Client
+	clientCfg  *ClientConfig
+	searchCfg  *SearchConfig
 }
 
-// NewClient creates new ESClient
-func NewClient(hosts []string, searchCfg *SearchConfig) ESClient {
-	return &client{
-		hosts:     hosts,
-		searchCfg: searchCfg,
-		re:        regexp.MustCompile(`\d+`),
-		hc:        &http.Client{},
+// NewClient creates new ESClient. Misconfigured authentication or TLS is reported as error instead of connecting with defaults
+func NewClient(hosts []string, clientCfg *ClientConfig, searchCfg *SearchConfig) (ESClient, error) {
+	if err := validateAuth(clientCfg); err != nil {
+		return nil, err
+	}
+	if err := validateRefresh(clientCfg.Refresh); err != nil {
+		return nil, err
 	}
+	hc, err := newHTTPClient(clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &client{
+		hosts:      newHostPool(hosts, clientCfg.DeadHostTimeout),
+		breaker:    newCircuitBreaker(clientCfg.BreakerThreshold, clientCfg.BreakerTimeout),
+		clientCfg:  clientCfg,
+		searchCfg:  searchCfg,
+		normalizer: newNormalizer(searchCfg),
+		strategy:   newScoringStrategy(searchCfg),
+		hc:         hc,
+	}, nil
 }
 
 func (rs *Response) String() string {
@@ -217,142 +295,235 @@ func (rs *Response) String() string {
 
 //Healthy returns TRUE if cluster in operational state
 func (c *client) Healthy() bool {
-	var rs map[string]interface{}
-	err := c.sendOpRequest("GET", c.buildURL("_cluster/health"), &rs, nil)
+	return c.HealthyContext(context.Background())
+}
+
+//HealthyContext returns TRUE if cluster in operational state
+func (c *client) HealthyContext(ctx context.Context) bool {
+	rs, err := c.ClusterHealthContext(ctx)
 	if nil != err {
 		return false
 	}
-	status := rs["status"]
-	return "yellow" == status || "green" == status
+	return rs.Operational()
+}
+
+//ClusterHealthContext obtains health of the cluster. Fails fast if circuit breaker is open
+func (c *client) ClusterHealthContext(ctx context.Context) (*ClusterHealth, error) {
+	if c.breaker.isOpen() {
+		return nil, ErrCircuitOpen
+	}
+	ctx, cancel := withTimeout(withOperation(ctx, "health"), c.clientCfg.RequestTimeout)
+	defer cancel()
+
+	rs := &ClusterHealth{}
+	if err := c.sendOpRequest(ctx, "GET", c.buildURL("_cluster/health"), rs, nil); nil != err {
+		return nil, err
+	}
+	return rs, nil
 }
 
+//ListIndices lists indices owned by the analyzer. Indices of other applications sharing the cluster are skipped.
+//Fails with ErrNoIndexPrefix if index prefix is not configured
 func (c *client) ListIndices() ([]Index, error) {
+	return c.ListIndicesContext(context.Background())
+}
+
+//ListIndicesContext lists indices owned by the analyzer
+func (c *client) ListIndicesContext(ctx context.Context) ([]Index, error) {
+	if "" == c.clientCfg.IndexPrefix {
+		return nil, ErrNoIndexPrefix
+	}
+	ctx, cancel := withTimeout(withOperation(ctx, "list_indices"), c.clientCfg.RequestTimeout)
+	defer cancel()
+
 	url := c.buildURL("_cat", "indices?format=json")
 
-	indices := []Index{}
+	all := []Index{}
 
-	err := c.sendOpRequest("GET", url, &indices)
+	err := c.sendOpRequest(ctx, "GET", url, &all)
 	if err != nil {
 		return nil, err
 	}
 
+	indices := []Index{}
+	for _, idx := range all {
+		if _, ok := c.parseIndexName(idx.Index); ok {
+			indices = append(indices, idx)
+		}
+	}
 	return indices, nil
 }
 
+//CreateIndex creates index of the current mapping version addressed through the alias of provided name
 func (c *client) CreateIndex(name string) (*Response, error) {
+	return c.CreateIndexContext(context.Background(), name)
+}
+
+//CreateIndexContext creates index of the current mapping version addressed through the alias of provided name
+func (c *client) CreateIndexContext(ctx context.Context, name string) (*Response, error) {
 	log.Debugf("Creating index %s", name)
+	ctx, cancel := withTimeout(withOperation(ctx, "create_index"), c.clientCfg.RequestTimeout)
+	defer cancel()
 
-	body := map[string]interface{}{
-		"settings": map[string]interface{}{
-			"number_of_shards": 1,
-			"analysis": map[string]interface{}{
-				"analyzer": map[string]interface{}{
-					"standard_english_analyzer": map[string]interface{}{
-						"type":      "standard",
-						"stopwords": "_english_",
-					},
-				},
-			},
-		},
-		"mappings": map[string]interface{}{
-			"properties": map[string]interface{}{
-				"test_item": map[string]interface{}{
-					"type": "keyword",
-				},
-				"issue_type": map[string]interface{}{
-					"type": "keyword",
-				},
-				"message": map[string]interface{}{
-					"type":     "text",
-					"analyzer": "standard_english_analyzer",
-				},
-				"log_level": map[string]interface{}{
-					"type": "integer",
-				},
-				"launch_name": map[string]interface{}{
-					"type": "keyword",
-				},
-				"unique_id": map[string]interface{}{
-					"type": "keyword",
-				},
-				"is_auto_analyzed": map[string]interface{}{
-					"type": "keyword",
-				},
-			},
-		},
-	}
+	body := indexTemplate()
+	body["aliases"] = map[string]interface{}{name: map[string]interface{}{}}
 
-	url := c.buildURL(name)
+	url := c.buildURL(versionedIndex(name, indexVersion))
 
 	rs := &Response{}
 
-	return rs, c.sendOpRequest(http.MethodPut, url, rs, body)
+	return rs, c.sendOpRequest(ctx, http.MethodPut, url, rs, body)
 }
 
 func (c *client) IndexExists(name string) (bool, error) {
+	return c.IndexExistsContext(context.Background(), name)
+}
+
+//IndexExistsContext checks whether index or alias of provided name exists
+func (c *client) IndexExistsContext(ctx context.Context, name string) (bool, error) {
 	log.Debugf("Checking index %s", name)
+	ctx, cancel := withTimeout(withOperation(ctx, "index_exists"), c.clientCfg.RequestTimeout)
+	defer cancel()
 
 	url := c.buildURL(name)
 
-	httpClient := &http.Client{}
-	rs, err := httpClient.Head(url)
+	status, _, err := c.doRequest(ctx, http.MethodHead, url, nil)
 	if err != nil {
 		return false, errors.WithStack(err)
 	}
-	defer rs.Body.Close()
-	return rs.StatusCode == http.StatusOK, nil
+	switch {
+	case http.StatusNotFound == status:
+		return false, nil
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		return true, nil
+	default:
+		return false, newESError(status, nil)
+	}
 }
 
 func (c *client) DeleteIndex(name int64) (*Response, error) {
+	return c.DeleteIndexContext(context.Background(), name)
+}
+
+//DeleteIndexContext deletes index of the project
+func (c *client) DeleteIndexContext(ctx context.Context, name int64) (*Response, error) {
 	log.Debugf("Deleting index %d", name)
-	url := c.buildURL(strconv.FormatInt(name, 10))
+	ctx, cancel := withTimeout(withOperation(ctx, "delete_index"), c.clientCfg.DeleteTimeout)
+	defer cancel()
+
+	//aliases cannot be deleted directly so indices behind the alias are resolved
+	indices, err := c.resolveAlias(ctx, c.indexName(name))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	url := c.buildURL(strings.Join(indices, ","))
 	rs := &Response{}
-	return rs, c.sendOpRequest(http.MethodDelete, url, rs)
+	err = c.sendOpRequest(ctx, http.MethodDelete, url, rs)
+	//index might be never created if nothing has been indexed for the project
+	if hasESStatus(err, http.StatusNotFound) {
+		esErr := errors.Cause(err).(*ESError)
+		return &Response{Status: esErr.Status, Error: esErr.ErrorDetails}, nil
+	}
+	return rs, err
 }
 
 func (c *client) DeleteLogs(ci *CleanIndex) (*Response, error) {
+	return c.DeleteLogsContext(context.Background(), ci)
+}
+
+//DeleteLogsContext deletes logs of the project. Fails with ErrIndexBlocked if logs cannot be deleted while index is migrated
+func (c *client) DeleteLogsContext(ctx context.Context, ci *CleanIndex) (*Response, error) {
 	log.Debugf("Deleting logs %v", ci.IDs)
+	ctx, cancel := withTimeout(withOperation(ctx, "delete_logs"), c.clientCfg.DeleteTimeout)
+	defer cancel()
+
 	url := c.buildURL("_bulk")
-	url = url + "?refresh"
-	rs := &Response{}
+	url = url + refreshParam(c.clientCfg.Refresh)
 	bodies := make([]interface{}, len(ci.IDs))
 	for i, id := range ci.IDs {
 		bodies[i] = map[string]interface{}{
 			"delete": map[string]interface{}{
 				"_id":    id,
-				"_index": ci.Project,
+				"_index": c.indexName(ci.Project),
 			},
 		}
 	}
-	return rs, c.sendOpRequest(http.MethodPost, url, rs, bodies...)
+	brs := &bulkDeleteResponse{}
+	if err := c.sendOpRequest(ctx, http.MethodPost, url, brs, bodies...); err != nil {
+		return nil, err
+	}
+	//logs left in the old index would be moved to the new one along with the rest of them
+	for _, item := range brs.Items {
+		if isBlockedItem(item.Delete.Error) {
+			return nil, errors.Wrapf(ErrIndexBlocked, "Cannot delete log %s", item.Delete.ID)
+		}
+	}
+	return &Response{}, nil
 }
 
 func (c *client) IndexLogs(launches []Launch) (*BulkResponse, error) {
+	return c.IndexLogsContext(context.Background(), launches)
+}
+
+//IndexLogsContext indexes error logs of the launches. Logs are sent within several bulk requests as soon as they are
+//prepared. Logs of the requests sent before one of them fails stay indexed, so logs are indexed at least once
+//when failed request is repeated. Repeated logs replace the documents of the same IDs
+func (c *client) IndexLogsContext(ctx context.Context, launches []Launch) (*BulkResponse, error) {
 	log.Debugf("Indexing logs for %d launches", len(launches))
+	ctx, cancel := withTimeout(withOperation(ctx, "index_logs"), c.clientCfg.IndexTimeout)
+	defer cancel()
+
+	url := c.buildURL("_bulk" + refreshParam(c.clientCfg.Refresh))
+	bi := newBulkIndexer(ctx, c.clientCfg.BulkSize, c.clientCfg.BulkBytes, c.clientCfg.BulkConcurrency,
+		func(ctx context.Context, docs []bulkDoc) (*BulkResponse, error) {
+			return c.bulkIndex(ctx, url, docs)
+		})
 
-	var bodies []interface{}
+	skipped, err := c.addLogs(ctx, bi, launches)
+	if nil != err {
+		//failure of the chunk is reported rather than cancellation it has caused
+		if bErr := bi.abort(); nil != bErr && isCancelled(err) {
+			err = bErr
+		}
+		return nil, err
+	}
+	rs, err := bi.close()
+	if err != nil {
+		return nil, err
+	}
+	rs.Summary.SkippedByLevel = skipped
+	log.Debugf("%d logs have been indexed, %d skipped by level, %d failed", rs.Summary.Indexed, rs.Summary.SkippedByLevel, rs.Summary.Failed)
+	for _, item := range rs.Items {
+		if isBlockedItem(item.Index.Error) {
+			return nil, errors.Wrapf(ErrIndexBlocked, "Cannot index log %s", item.Index.ID)
+		}
+	}
+	return rs, nil
+}
 
+//addLogs adds error logs of the launches to the bulk indexer. Returns count of logs skipped by level
+func (c *client) addLogs(ctx context.Context, bi *bulkIndexer, launches []Launch) (int, error) {
+	skipped := 0
 	for _, lc := range launches {
-		if err := c.createIndexIfNotExists(strconv.FormatInt(lc.Project, 10)); nil != err {
-			return nil, errors.Wrap(err, "Cannot index logs")
+		if err := c.createIndexIfNotExists(ctx, c.indexName(lc.Project)); nil != err {
+			return skipped, errors.Wrap(err, "Cannot index logs")
 		}
 		for _, ti := range lc.TestItems {
 			for _, l := range ti.Logs {
 
 				if l.LogLevel < ErrorLoggingLevel {
+					skipped++
 					continue
 				}
 
 				op := map[string]interface{}{
 					"index": map[string]interface{}{
 						"_id":    l.LogID,
-						"_index": lc.Project,
+						"_index": c.indexName(lc.Project),
 					},
 				}
 
-				bodies = append(bodies, op)
-
-				message := c.sanitizeText(firstLines(l.Message, lc.Conf.LogLines))
+				pl := c.normalizer.Parse(l.Message, lc.Conf.LogLines, c.searchCfg.StackTraceFrames)
 
 				body := map[string]interface{}{
 					"launch_id":        lc.LaunchID,
@@ -362,149 +533,282 @@ func (c *client) IndexLogs(launches []Launch) (*BulkResponse, error) {
 					"is_auto_analyzed": ti.IsAutoAnalyzed,
 					"issue_type":       ti.IssueType,
 					"log_level":        l.LogLevel,
-					"message":          message,
+					"message":          pl.Message,
+				}
+				if "" != pl.ExceptionType {
+					body["exception_type"] = pl.ExceptionType
+					body["exception_message"] = pl.ExceptionMessage
+					body["stack_trace"] = pl.StackTrace
+					body["text"] = pl.Text
+				}
+				if nil != l.LogTime {
+					body["log_time"] = l.LogTime
 				}
 
-				bodies = append(bodies, body)
+				doc, err := newBulkDoc(l.LogID, ti.TestItemID, op, body)
+				if nil != err {
+					return skipped, errors.Wrap(err, "Cannot index logs")
+				}
+				if err := bi.add(doc); nil != err {
+					return skipped, errors.Wrap(err, "Cannot index logs")
+				}
 			}
 		}
 	}
-
-	rs := &BulkResponse{}
-
-	if len(bodies) == 0 {
-		return rs, nil
-	}
-
-	url := c.buildURL("_bulk?refresh")
-
-	return rs, c.sendOpRequest(http.MethodPut, url, rs, bodies...)
+	return skipped, nil
 }
 
 func (c *client) AnalyzeLogs(launches []Launch) ([]AnalysisResult, error) {
+	return c.AnalyzeLogsContext(context.Background(), launches)
+}
+
+//AnalyzeLogsContext predicts issue types of the launches test items
+func (c *client) AnalyzeLogsContext(ctx context.Context, launches []Launch) ([]AnalysisResult, error) {
 	log.Debugf("Starting analysis for %d launches", len(launches))
+	ctx, cancel := withTimeout(withOperation(ctx, "analyze_logs"), c.clientCfg.AnalyzeTimeout)
+	defer cancel()
 
-	result := []AnalysisResult{}
+	type job struct {
+		lc       Launch
+		ti       TestItem
+		strategy ScoringStrategy
+	}
+	var jobs []job
 	for _, lc := range launches {
-		url := c.buildURL(strconv.FormatInt(lc.Project, 10), "_search")
-
+		strategy := launchScoringStrategy(c.strategy, c.searchCfg, lc.Conf)
 		for _, ti := range lc.TestItems {
-			issueTypes := make(map[string]*score)
+			jobs = append(jobs, job{lc, ti, strategy})
+		}
+	}
 
-			for _, l := range ti.Logs {
+	//test items are analyzed concurrently. Predictions are collected
+	//by job index to keep results in the same order as test items are requested
+	predictions := make([]*AnalysisResult, len(jobs))
+	errs := make([]error, len(jobs))
 
-				if l.LogLevel < ErrorLoggingLevel {
-					continue
-				}
+	workers := c.clientCfg.AnalyzeConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			//rest of the test items are not scheduled once analysis is cancelled
+			errs[i] = errors.WithStack(ctx.Err())
+		}
+		if nil != errs[i] {
+			break
+		}
+		wg.Add(1)
+		go func(i int, j job) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			predictions[i], errs[i] = c.analyzeTestItem(ctx, j.lc, j.ti, j.strategy)
+		}(i, j)
+	}
+	wg.Wait()
+	//predictions of partially analyzed launches are not reported
+	if err := ctx.Err(); nil != err {
+		return nil, errors.WithStack(err)
+	}
 
-				message := c.sanitizeText(firstLines(l.Message, lc.Conf.LogLines))
+	result := []AnalysisResult{}
+	for i := range jobs {
+		if errs[i] != nil {
+			return nil, errors.WithStack(errs[i])
+		}
+		if nil != predictions[i] {
+			result = append(result, *predictions[i])
+		}
+	}
+	log.Debugf("Analysis has found %d matches", len(result))
 
-				query := c.buildAnalyzeQuery(lc, ti.UniqueID, message)
+	return result, nil
+}
 
-				rs := &SearchResult{}
-				err := c.sendOpRequest(http.MethodGet, url, rs, query)
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
+//analyzeTestItem searches for similar logs of all error logs of the test item within single multi search request
+func (c *client) analyzeTestItem(ctx context.Context, lc Launch, ti TestItem, strategy ScoringStrategy) (*AnalysisResult, error) {
+	var queries []interface{}
+	for _, l := range ti.Logs {
+		if l.LogLevel < ErrorLoggingLevel {
+			continue
+		}
 
-				calculateScores(rs, 10, issueTypes)
-			}
+		pl := c.normalizer.Parse(l.Message, lc.Conf.LogLines, c.searchCfg.StackTraceFrames)
+		queries = append(queries, c.buildAnalyzeQuery(lc, ti.UniqueID, pl))
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
 
-			var predictedIssueType string
+	url := c.buildURL(c.indexName(lc.Project), "_msearch")
+	results, err := c.multiSearch(ctx, url, queries)
+	//nothing has been indexed for the project yet so there are no similar logs
+	if hasESStatus(err, http.StatusNotFound) {
+		results, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-			if len(issueTypes) > 0 {
-				max := 0.0
-				for k, v := range issueTypes {
-					if v.score > max {
-						max = v.score
-						predictedIssueType = k
-					}
-				}
-			}
-			if "" != predictedIssueType {
-				result = append(result, AnalysisResult{
-					TestItem:     ti.TestItemID,
-					RelevantItem: issueTypes[predictedIssueType].mrHit.Source.TestItem,
-					IssueType:    predictedIssueType,
-				})
-			}
+	_, span := tracer.Start(ctx, "scoring", trace.WithAttributes(
+		attribute.Int64("test_item", ti.TestItemID),
+		attribute.Int("queries", len(queries)),
+	))
+	defer span.End()
 
+	issueTypes := make(map[string]*score)
+	for _, rs := range results {
+		strategy.Score(rs, 10, issueTypes)
+	}
+	prediction := predictIssueType(c.searchCfg, ti.TestItemID, issueTypes)
+	if nil == prediction {
+		if lc.Explain {
+			return unpredicted(c.searchCfg, ti.TestItemID, explain(queries, results, 10, issueTypes)), nil
 		}
+		return nil, nil
 	}
-	log.Debugf("Analysis has found %d matches", len(result))
+	span.SetAttributes(attribute.String("issue_type", prediction.IssueType), attribute.Float64("score", prediction.Score))
+	if lc.Explain {
+		prediction.Explanation = explain(queries, results, 10, issueTypes)
+	}
+	return prediction, nil
+}
 
-	return result, nil
+//multiSearch sends queries within single _msearch request. Results are returned in the same order as queries.
+//Searches on not existing index result in empty results
+func (c *client) multiSearch(ctx context.Context, url string, queries []interface{}) ([]*SearchResult, error) {
+	bodies := make([]interface{}, 0, 2*len(queries))
+	for _, q := range queries {
+		//index is provided in URL so header is empty
+		bodies = append(bodies, map[string]interface{}{}, q)
+	}
+
+	rs := &MultiSearchResult{}
+	if err := c.sendOpRequest(ctx, http.MethodGet, url, rs, bodies...); err != nil {
+		return nil, err
+	}
+	if len(rs.Responses) != len(queries) {
+		return nil, errors.Errorf("Unexpected number of ES multi search responses. Expected %d, got %d", len(queries), len(rs.Responses))
+	}
+
+	results := make([]*SearchResult, len(queries))
+	for i := range rs.Responses {
+		item := rs.Responses[i]
+		switch {
+		case nil == item.Error:
+			results[i] = &item.SearchResult
+		case http.StatusNotFound == item.Status:
+			results[i] = &SearchResult{}
+		default:
+			return nil, &ESError{Status: item.Status, ErrorDetails: *item.Error}
+		}
+	}
+	return results, nil
 }
 
+//SearchLogs searches for logs similar to all the messages of the request within single multi search request
 func (c *client) SearchLogs(request SearchLogs) ([]int64, error) {
+	return c.SearchLogsContext(context.Background(), request)
+}
+
+//SearchLogsContext searches for logs similar to all the messages of the request
+func (c *client) SearchLogsContext(ctx context.Context, request SearchLogs) ([]int64, error) {
+	ctx, cancel := withTimeout(withOperation(ctx, "search_logs"), c.clientCfg.SearchTimeout)
+	defer cancel()
+
+	keys := []int64{}
+	if len(request.LogMessages) == 0 {
+		return keys, nil
+	}
+
+	queries := make([]interface{}, len(request.LogMessages))
+	for i, message := range request.LogMessages {
+		sanitizedMsg := c.normalize(message, request.LogLines)
+		queries[i] = c.buildSearchQuery(request, sanitizedMsg)
+	}
+
+	url := c.buildURL(c.indexName(request.ProjectID), "_msearch")
+	results, err := c.multiSearch(ctx, url, queries)
+	//nothing has been indexed for the project yet
+	if hasESStatus(err, http.StatusNotFound) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	//logs found by all the messages are merged into single list without duplicates.
+	//Responses are in the same order as messages so count of logs found by each message is logged
 	set := make(map[int64]bool)
-	for _, message := range request.LogMessages {
-		url := c.buildURL(strconv.FormatInt(request.ProjectID, 10), "_search")
-		sanitizedMsg := c.sanitizeText(firstLines(message, request.LogLines))
-		query := c.buildSearchQuery(request, sanitizedMsg)
-
-		response := &SearchResult{}
-		err := c.sendOpRequest(http.MethodGet, url, response, query)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		for _, log := range response.Hits.Hits {
-			logIndex, err := strconv.ParseInt(log.ID, 10, 64)
+	for i, response := range results {
+		log.Debugf("Search for message [%d] of test item %d has found %d logs", i, request.ItemID, len(response.Hits.Hits))
+		for _, hit := range response.Hits.Hits {
+			logIndex, err := strconv.ParseInt(hit.ID, 10, 64)
 			if err != nil {
 				return nil, errors.WithStack(err)
 			}
-			set[logIndex] = true
+			if !set[logIndex] {
+				set[logIndex] = true
+				keys = append(keys, logIndex)
+			}
 		}
 	}
-	keys := make([]int64, len(set))
-
-	i := 0
-	for k := range set {
-		keys[i] = k
-		i++
-	}
 
 	return keys, nil
 }
 
-func (c *client) createIndexIfNotExists(indexName string) error {
+func (c *client) createIndexIfNotExists(ctx context.Context, indexName string) error {
 
-	exists, err := c.IndexExists(indexName)
+	exists, err := c.IndexExistsContext(ctx, indexName)
 	if err != nil {
 		return errors.Wrap(err, "Cannot check ES index exists")
 	}
 	if !exists {
-		_, err = c.CreateIndex(indexName)
+		_, err = c.CreateIndexContext(ctx, indexName)
 	}
 	return errors.Wrap(err, "Cannot create ES index")
 }
 
-func (c *client) sanitizeText(text string) string {
-	return c.re.ReplaceAllString(text, "")
+//normalize normalizes first lines of log message
+func (c *client) normalize(message string, lines int) string {
+	return c.normalizer.Normalize(message, lines)
 }
 
+//buildURL builds URL relative to ES host. Host is selected for each request separately
 func (c *client) buildURL(pathElements ...string) string {
-	return c.hosts[0] + "/" + strings.Join(pathElements, "/")
+	return "/" + strings.Join(pathElements, "/")
 }
 
-func (c *client) buildAnalyzeQuery(launch Launch, uniqueID, logMessage string) interface{} {
-	minDocFreq := launch.Conf.MinDocFreq
+//analyzeParams resolves more-like-this parameters of the launch falling back to the global search config
+func analyzeParams(cfg *SearchConfig, conf AnalyzerConf) (minDocFreq, minTermFreq float64, minShouldMatch string) {
+	minDocFreq = conf.MinDocFreq
 	if 0 == minDocFreq {
-		minDocFreq = c.searchCfg.MinDocFreq
+		minDocFreq = cfg.MinDocFreq
 	}
-	minTermFreq := launch.Conf.MintTermFreq
+	minTermFreq = conf.MintTermFreq
 	if 0 == minTermFreq {
-		minTermFreq = c.searchCfg.MinTermFreq
+		minTermFreq = cfg.MinTermFreq
 	}
-	var minShouldMatch string
-	if 0 == launch.Conf.MinShouldMatch {
-		minShouldMatch = c.searchCfg.MinShouldMatch
+	if 0 == conf.MinShouldMatch {
+		minShouldMatch = cfg.MinShouldMatch
 	} else {
-		minShouldMatch = fmt.Sprintf("%s%%", strconv.Itoa(launch.Conf.MinShouldMatch))
+		minShouldMatch = fmt.Sprintf("%s%%", strconv.Itoa(conf.MinShouldMatch))
 	}
+	return
+}
+
+func (c *client) buildAnalyzeQuery(launch Launch, uniqueID string, pl parsedLog) interface{} {
+	minDocFreq, minTermFreq, minShouldMatch := analyzeParams(c.searchCfg, launch.Conf)
 
 	q := EsQueryRQ{
-		Size: 10,
+		Size:    10,
+		Explain: launch.Explain,
 		Query: &EsQuery{
 			Bool: &BoolCondition{
 				MustNot: &Condition{
@@ -536,22 +840,56 @@ func (c *client) buildAnalyzeQuery(launch Launch, uniqueID, logMessage string) i
 		q.Query.Bool.Should = append(q.Query.Bool.Should, Condition{
 			Term: map[string]TermCondition{"launch_name": {launch.LaunchName, NewBoost(math.Abs(c.searchCfg.BoostLaunch))}},
 		})
-		q.Query.Bool.Must = append(q.Query.Bool.Must, c.buildMoreLikeThis(minDocFreq, minTermFreq, c.searchCfg.MaxQueryTerms, minShouldMatch, logMessage))
 	case SearchModeLaunchName:
 		q.Query.Bool.Must = append(q.Query.Bool.Must, Condition{
 			Term: map[string]TermCondition{"launch_name": {Value: launch.LaunchName}},
 		})
-		q.Query.Bool.Must = append(q.Query.Bool.Must, c.buildMoreLikeThis(minDocFreq, minTermFreq, c.searchCfg.MaxQueryTerms, minShouldMatch, logMessage))
 	case SearchModeCurrentLaunch:
 		q.Query.Bool.Must = append(q.Query.Bool.Must, Condition{
 			Term: map[string]TermCondition{"launch_id": {Value: launch.LaunchID}},
 		})
-		q.Query.Bool.Must = append(q.Query.Bool.Must, c.buildMoreLikeThis(float64(1), minTermFreq, c.searchCfg.MaxQueryTerms, minShouldMatch, logMessage))
+		minDocFreq = 1
+	}
+	q.Query.Bool.Must = append(q.Query.Bool.Must, c.buildLogSimilarity(minDocFreq, minTermFreq, minShouldMatch, pl))
+	if "" != pl.ExceptionType {
+		q.Query.Bool.Should = append(q.Query.Bool.Should, Condition{
+			Term: map[string]TermCondition{"exception_type": {pl.ExceptionType, NewBoost(math.Abs(c.searchCfg.BoostExceptionType))}},
+		})
 	}
 
 	return q
 }
 
+//buildLogSimilarity builds condition matching similar logs. Logs having exception are matched
+//either by the whole message or by its parts weighted separately
+func (c *client) buildLogSimilarity(minDocFreq, minTermFreq float64, minShouldMatch string, pl parsedLog) Condition {
+	message := c.buildMoreLikeThis(minDocFreq, minTermFreq, c.searchCfg.MaxQueryTerms, minShouldMatch, pl.Message)
+	if "" == pl.ExceptionType {
+		return message
+	}
+
+	similarity := &BoolCondition{Should: []Condition{message}, MinimumShouldMatch: 1}
+	fields := []struct {
+		name  string
+		like  string
+		boost float64
+	}{
+		{"exception_message", pl.ExceptionMessage, c.searchCfg.BoostExceptionMessage},
+		{"stack_trace", pl.StackTrace, c.searchCfg.BoostStackTrace},
+		{"text", pl.Text, c.searchCfg.BoostText},
+	}
+	for _, f := range fields {
+		if "" == strings.TrimSpace(f.like) {
+			continue
+		}
+		mlt := c.buildMoreLikeThis(minDocFreq, minTermFreq, c.searchCfg.MaxQueryTerms, minShouldMatch, f.like)
+		mlt.MoreLikeThis.Fields = []string{f.name}
+		mlt.MoreLikeThis.Boost = NewBoost(math.Abs(f.boost))
+		similarity.Should = append(similarity.Should, mlt)
+	}
+	return Condition{Bool: similarity}
+}
+
 func (c *client) buildSearchQuery(request SearchLogs, logMessage string) interface{} {
 	q := EsQueryRQ{
 		Size: 500,
@@ -647,8 +985,82 @@ func calculateScores(rs *SearchResult, k int, scores map[string]*score) {
 	}
 }
 
-func (c *client) sendOpRequest(method, url string, response interface{}, bodies ...interface{}) error {
-	rs, err := c.sendRequest(method, url, bodies...)
+//predictIssueType picks issue type with the highest total score
+//Prediction having confidence lower than configured one is skipped
+//returns nil if there is nothing to predict
+func predictIssueType(cfg *SearchConfig, testItem int64, issueTypes map[string]*score) *AnalysisResult {
+	candidates := rankIssueTypes(issueTypes)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	predicted := candidates[0]
+	if predicted.Score < cfg.MinConfidence {
+		log.Debugf("Prediction %s for test item %d is skipped. Confidence %.2f is lower than %.2f",
+			predicted.IssueType, testItem, predicted.Score, cfg.MinConfidence)
+		return nil
+	}
+
+	alternatives := candidates[1:]
+	if len(alternatives) > cfg.MaxAlternatives {
+		alternatives = alternatives[:cfg.MaxAlternatives]
+	}
+	if len(alternatives) == 0 {
+		alternatives = nil
+	}
+
+	return &AnalysisResult{
+		TestItem:     testItem,
+		RelevantItem: predicted.RelevantItem,
+		RelevantLog:  predicted.RelevantLog,
+		IssueType:    predicted.IssueType,
+		Score:        predicted.Score,
+		Alternatives: alternatives,
+	}
+}
+
+//rankIssueTypes orders issue types by score normalized so scores sum up to 1
+func rankIssueTypes(issueTypes map[string]*score) []IssueTypeScore {
+	total := 0.0
+	candidates := make([]IssueTypeScore, 0, len(issueTypes))
+	for k, v := range issueTypes {
+		if v.score <= 0 {
+			continue
+		}
+		total += v.score
+		candidates = append(candidates, IssueTypeScore{
+			IssueType:    k,
+			Score:        v.score,
+			RelevantItem: v.mrHit.Source.TestItem,
+			RelevantLog:  relevantLog(v.mrHit),
+		})
+	}
+
+	//issue type is used as a tie breaker to keep prediction stable
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score == candidates[j].Score {
+			return candidates[i].IssueType < candidates[j].IssueType
+		}
+		return candidates[i].Score > candidates[j].Score
+	})
+	for i := range candidates {
+		candidates[i].Score /= total
+	}
+	return candidates
+}
+
+//relevantLog obtains ID of the log hit is found by
+func relevantLog(h Hit) int64 {
+	id, err := strconv.ParseInt(h.ID, 10, 64)
+	if err != nil {
+		log.Warnf("Unexpected ID of log document: %s", h.ID)
+		return 0
+	}
+	return id
+}
+
+func (c *client) sendOpRequest(ctx context.Context, method, url string, response interface{}, bodies ...interface{}) error {
+	rs, err := c.sendRequest(ctx, method, url, bodies...)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -661,55 +1073,180 @@ func (c *client) sendOpRequest(method, url string, response interface{}, bodies
 	return nil
 }
 
-func (c *client) sendRequest(method, url string, bodies ...interface{}) ([]byte, error) {
-	var rdr io.Reader
-
-	nl := []byte("\n")
+func (c *client) sendRequest(ctx context.Context, method, url string, bodies ...interface{}) (rs []byte, err error) {
+	ctx, span := tracer.Start(ctx, "es "+operation(ctx),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "elasticsearch"),
+			attribute.String("db.operation", operation(ctx)),
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", url),
+		))
+	defer func() { endSpan(span, err) }()
+
+	var rqBody requestBody
 	if len(bodies) > 0 {
-		buff := bytes.NewBuffer([]byte{})
+		rqBody = func() io.ReadCloser { return streamBodies(bodies) }
+	}
+
+	status, rsBody, err := c.doRequest(ctx, method, url, rqBody)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		log.Errorf("ES communication error. Status code %d, Body %s", status, string(rsBody))
+		return nil, newESError(status, rsBody)
+	}
+
+	log.Debugf("Response from ES - %v", string(rsBody))
+
+	return rsBody, nil
+}
+
+//requestBody opens body of the request. Body is opened for each attempt so request may be repeated
+type requestBody func() io.ReadCloser
+
+//encodeError means request body cannot be encoded. Such request is never sent to ES
+type encodeError struct {
+	err error
+}
+
+func (e *encodeError) Error() string {
+	return "Cannot encode ES request: " + e.err.Error()
+}
+
+func isEncodeError(err error) bool {
+	encErr := (*encodeError)(nil)
+	return errors.As(err, &encErr)
+}
+
+//streamBodies encodes bodies while request is being sent so request body is never buffered as a whole.
+//Bodies are separated by new line as bulk and multi search APIs require
+func streamBodies(bodies []interface{}) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
 		for _, body := range bodies {
-			rqBody, err := json.Marshal(body)
-			if err != nil {
-				return nil, errors.WithStack(err)
+			if err := enc.Encode(body); nil != err {
+				pw.CloseWithError(&encodeError{err: err})
+				return
 			}
-			// nolint
-			buff.Write(rqBody)
-			// nolint
-			buff.Write(nl)
 		}
-		rdr = buff
+		// nolint
+		pw.Close()
+	}()
+	return pr
+}
+
+//doRequest sends request with retries. Waits with exponential backoff between attempts
+//Fails fast without sending request if circuit breaker is open or context is done
+func (c *client) doRequest(ctx context.Context, method, url string, rqBody requestBody) (status int, rsBody []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); nil != err {
+			return 0, nil, errors.WithStack(err)
+		}
+		allowed, trial := c.breaker.allow()
+		if !allowed {
+			return 0, nil, ErrCircuitOpen
+		}
+
+		status, rsBody, err = c.doFailoverRequest(ctx, method, url, rqBody)
+		//cancelled or timed out request says nothing about cluster health as well as the one never built
+		if nil != ctx.Err() || isEncodeError(err) {
+			if trial {
+				c.breaker.release()
+			}
+			return status, rsBody, err
+		}
+		c.breaker.record(!isFailure(status, err))
+
+		if attempt >= c.clientCfg.MaxRetries || !isRetryable(method, status, err) {
+			return status, rsBody, err
+		}
+
+		delay := backoff(attempt, c.clientCfg.RetryBackoff, c.clientCfg.MaxRetryBackoff)
+		log.Warnf("ES request %s %s failed. Retrying in %v", method, url, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return status, rsBody, errors.WithStack(ctx.Err())
+		}
 	}
+}
 
-	rq, err := http.NewRequest(method, url, rdr)
-	log.Debugf("Request to ES - method: %q;\n url: %q;\n body: %v", method, url, rdr)
+//doFailoverRequest sends request to the next healthy host. If host is not reachable or responds with 502, 503 or 504 status
+//it's marked as dead and request is retried on the next one
+func (c *client) doFailoverRequest(ctx context.Context, method, url string, rqBody requestBody) (status int, rsBody []byte, err error) {
+	for attempt := 0; attempt < c.hosts.size(); attempt++ {
+		h := c.hosts.nextHost()
+		status, rsBody, err = c.doHostRequest(ctx, h, method, url, rqBody)
+		if !isHostFailure(status, err) {
+			c.hosts.markAlive(h)
+			return status, rsBody, nil
+		}
+		//host is not blamed for the request abandoned by the caller or the one never built
+		if nil != ctx.Err() || isEncodeError(err) {
+			return status, rsBody, err
+		}
+		c.hosts.markDead(h)
+	}
+	return status, rsBody, err
+}
+
+func (c *client) doHostRequest(ctx context.Context, h *esHost, method, url string, rqBody requestBody) (int, []byte, error) {
+	var rdr io.ReadCloser
+	if nil != rqBody {
+		rdr = rqBody()
+	}
+
+	rq, err := http.NewRequest(method, h.url+url, rdr)
+	log.Debugf("Request to ES - method: %q;\n url: %q", method, h.url+url)
 	if err != nil {
-		return nil, errors.Wrap(err, "Cannot build request to ES")
+		if nil != rdr {
+			// nolint
+			rdr.Close()
+		}
+		return 0, nil, errors.Wrap(err, "Cannot build request to ES")
 	}
+	rq = rq.WithContext(ctx)
 	rq.Header.Set("Content-Type", "application/json")
+	authorize(rq, c.clientCfg)
 
+	start := time.Now()
 	rs, err := c.hc.Do(rq)
 	if err != nil {
+		observeESRequest(ctx, start, 0, err)
 		log.Errorf("Cannot send request to ES: %s", err.Error())
+		//context error is exposed as the cause so callers may tell timeout and cancellation apart
+		if nil != ctx.Err() {
+			err = ctx.Err()
+		}
+		if encErr := (*encodeError)(nil); errors.As(err, &encErr) {
+			err = encErr
+		}
 
-		return nil, errors.Wrap(err, "Cannot send request to ES")
+		return 0, nil, errors.Wrap(err, "Cannot send request to ES")
 	}
 	defer rs.Body.Close()
 
 	rsBody, err := ioutil.ReadAll(rs.Body)
 	if err != nil {
 		log.Errorf("Cannot ES response: %s", err.Error())
-		return nil, errors.Wrap(err, "Cannot read ES response")
+		observeESRequest(ctx, start, 0, err)
+		return 0, nil, errors.Wrap(err, "Cannot read ES response")
 	}
+	observeESRequest(ctx, start, rs.StatusCode, nil)
+	return rs.StatusCode, rsBody, nil
+}
 
-	if rs.StatusCode > http.StatusCreated && rs.StatusCode < http.StatusNotFound {
-		body := string(rsBody)
-		log.Errorf("ES communication error. Status code %d, Body %s", rs.StatusCode, body)
-		return nil, errors.New(body)
+//withTimeout limits context by the operation timeout. Zero timeout means no limit
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-
-	log.Debugf("Response from ES - %v", string(rsBody))
-
-	return rsBody, nil
+	return context.WithTimeout(ctx, timeout)
 }
 
 // findNth searches for the nth occurrence of string
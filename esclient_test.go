@@ -17,11 +17,12 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/reportportal/commons-go/conf"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/reportportal/commons-go/server"
@@ -60,7 +61,9 @@ type ServerCall struct {
 func TestListIndices(t *testing.T) {
 	tests := []struct {
 		calls         []ServerCall
+		prefix        string
 		expectedCount int
+		expectedNames []string
 		expectErr     bool
 	}{
 		{
@@ -72,6 +75,7 @@ func TestListIndices(t *testing.T) {
 					status: http.StatusOK,
 				},
 			},
+			prefix:        "rp_",
 			expectedCount: 0,
 			expectErr:     false,
 		},
@@ -84,9 +88,29 @@ func TestListIndices(t *testing.T) {
 					status: http.StatusOK,
 				},
 			},
+			prefix:        "rp_",
 			expectedCount: 2,
+			expectedNames: []string{"rp_0", "rp_1_v2"},
 			expectErr:     false,
 		},
+		{
+			//indices of the analyzer cannot be told apart from the other ones
+			calls:     []ServerCall{},
+			expectErr: true,
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					rs:     `[{"index":"rp_1_v2"},{"index":"1_v2"},{"index":"rp_logs"},{"index":"rp_2"}]`,
+					status: http.StatusOK,
+				},
+			},
+			prefix:        "rp_",
+			expectedCount: 2,
+			expectedNames: []string{"rp_1_v2", "rp_2"},
+		},
 		{
 			calls: []ServerCall{
 				{
@@ -95,6 +119,7 @@ func TestListIndices(t *testing.T) {
 					status: http.StatusInternalServerError,
 				},
 			},
+			prefix:    "rp_",
 			expectErr: true,
 		},
 	}
@@ -103,7 +128,9 @@ func TestListIndices(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
+		cfg := defaultClientConfig()
+		cfg.IndexPrefix = test.prefix
+		c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
 
 		indices, err := c.ListIndices()
 
@@ -115,7 +142,7 @@ func TestListIndices(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, test.expectedCount, len(indices))
 			for j, idx := range indices {
-				assert.Equal(t, fmt.Sprintf("idx%d", j), idx.Index)
+				assert.Equal(t, test.expectedNames[j], idx.Index)
 			}
 		}
 	}
@@ -131,7 +158,7 @@ func TestCreateIndex(t *testing.T) {
 			calls: []ServerCall{
 				{
 					method: "PUT",
-					uri:    "/idx0",
+					uri:    "/idx0_v2",
 					rs:     getFixture(IndexCreatedRs),
 					status: http.StatusOK,
 				},
@@ -143,7 +170,7 @@ func TestCreateIndex(t *testing.T) {
 			calls: []ServerCall{
 				{
 					method: "PUT",
-					uri:    "/idx1",
+					uri:    "/idx1_v2",
 					rs:     getFixture(IndexAlreadyExistsRs),
 					status: http.StatusBadRequest,
 				},
@@ -157,7 +184,7 @@ func TestCreateIndex(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
 
 		rs, err := c.CreateIndex(test.index)
 
@@ -206,7 +233,7 @@ func TestIndexExists(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
 
 		exists, err := c.IndexExists(test.index)
 
@@ -225,9 +252,15 @@ func TestDeleteIndex(t *testing.T) {
 	}{
 		{
 			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_alias/1",
+					rs:     `{"1_v2":{"aliases":{"1":{}}}}`,
+					status: http.StatusOK,
+				},
 				{
 					method: "DELETE",
-					uri:    "/1",
+					uri:    "/1_v2",
 					rs:     getFixture(IndexDeletedRs),
 					status: http.StatusOK,
 				},
@@ -237,6 +270,12 @@ func TestDeleteIndex(t *testing.T) {
 		},
 		{
 			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_alias/2",
+					rs:     `{"error":"alias [2] missing","status":404}`,
+					status: http.StatusNotFound,
+				},
 				{
 					method: "DELETE",
 					uri:    "/2",
@@ -253,7 +292,7 @@ func TestDeleteIndex(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
 
 		rs, err := c.DeleteIndex(test.index)
 
@@ -298,7 +337,7 @@ func TestIndexLogs(t *testing.T) {
 				},
 				{
 					method: "PUT",
-					uri:    "/2",
+					uri:    "/2_v2",
 					rs:     getFixture(IndexCreatedRs),
 					status: http.StatusOK,
 				},
@@ -321,7 +360,7 @@ func TestIndexLogs(t *testing.T) {
 				},
 				{
 					method: "PUT",
-					uri:    "/2",
+					uri:    "/2_v2",
 					rs:     getFixture(IndexCreatedRs),
 					status: http.StatusOK,
 				},
@@ -341,7 +380,7 @@ func TestIndexLogs(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
 
 		launches := []Launch{}
 		err := json.Unmarshal([]byte(test.indexRq), &launches)
@@ -372,16 +411,9 @@ func TestAnalyzeLogs(t *testing.T) {
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(NoHitsSearchRs),
-					status: http.StatusOK,
-				},
-				{
-					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(NoHitsSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(NoHitsSearchRs)),
 					status: http.StatusOK,
 				},
 			},
@@ -391,16 +423,22 @@ func TestAnalyzeLogs(t *testing.T) {
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(NoHitsSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(OneHitSearchRs)),
 					status: http.StatusOK,
 				},
+			},
+			analyzeRq:     getFixture(LaunchWTestItemsWLogs),
+			expectedIssue: "AB001",
+		},
+		{
+			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(OneHitSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(OneHitSearchRs), getFixture(TwoHitsSearchRs)),
 					status: http.StatusOK,
 				},
 			},
@@ -411,74 +449,162 @@ func TestAnalyzeLogs(t *testing.T) {
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(OneHitSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(TwoHitsSearchRs), getFixture(ThreeHitsSearchRs)),
 					status: http.StatusOK,
 				},
+			},
+			analyzeRq:     getFixture(LaunchWTestItemsWLogs),
+			expectedIssue: "AB001",
+		},
+		{
+			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(TwoHitsSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(ThreeHitsSearchRs)),
 					status: http.StatusOK,
 				},
 			},
 			analyzeRq:     getFixture(LaunchWTestItemsWLogs),
-			expectedIssue: "AB001",
+			expectedIssue: "PB001",
 		},
 		{
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(TwoHitsSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(1, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(TwoHitsSearchRs)),
 					status: http.StatusOK,
 				},
+			},
+			analyzeRq:     getFixture(LaunchWTestItemsWLogsDifferentLogLevel),
+			expectedIssue: "AB001",
+		},
+		{
+			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(ThreeHitsSearchRs),
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     `{"responses":[` + getFixture(OneHitSearchRs) + `,` + getFixture(IndexNotFoundRs) + `]}`,
 					status: http.StatusOK,
 				},
 			},
 			analyzeRq:     getFixture(LaunchWTestItemsWLogs),
 			expectedIssue: "AB001",
 		},
+	}
+
+	for _, test := range tests {
+		i := 0
+		ts := startServer(t, test.calls, &i)
+		defer ts.Close()
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+
+		launches := []Launch{}
+		err := json.Unmarshal([]byte(test.analyzeRq), &launches)
+		assert.NoError(t, err)
+
+		results, err := c.AnalyzeLogs(launches)
+		assert.NoError(t, err)
+
+		assert.Equal(t, len(test.calls), i)
+		if test.expectedIssue != "" {
+			assert.Equal(t, test.expectedIssue, results[0].IssueType)
+		}
+	}
+}
+
+func TestAnalyzeLogsKeepsOrder(t *testing.T) {
+	var launch Launch
+	assert.NoError(t, json.Unmarshal([]byte(`{"launchId":1,"project":2,"launchName":"launch"}`), &launch))
+	issues := map[string]string{"1": OneHitSearchRs, "2": NoHitsSearchRs, "3": ThreeHitsSearchRs, "4": TwoHitsSearchRs}
+	for i := 1; i <= 4; i++ {
+		launch.TestItems = append(launch.TestItems, TestItem{
+			TestItemID: int64(i),
+			UniqueID:   strconv.Itoa(i),
+			Logs:       []Log{{LogID: int64(i), LogLevel: ErrorLoggingLevel, Message: "message"}},
+		})
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rq, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		//unique ID of the test item is a part of the query
+		for id, rs := range issues {
+			if strings.Contains(string(rq), `"unique_id":{"value":"`+id+`"`) {
+				if _, wErr := w.Write([]byte(msearchRs(getFixture(rs)))); wErr != nil {
+					log.Error(wErr)
+				}
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	cfg := defaultClientConfig()
+	cfg.AnalyzeConcurrency = 3
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	results, err := c.AnalyzeLogs([]Launch{launch})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, int64(1), results[0].TestItem)
+		assert.Equal(t, int64(3), results[1].TestItem)
+		assert.Equal(t, int64(4), results[2].TestItem)
+	}
+}
+
+func TestSearchLogs(t *testing.T) {
+	tests := []struct {
+		calls       []ServerCall
+		messages    []string
+		expectedIDs []int64
+	}{
+		{
+			calls:       []ServerCall{},
+			expectedIDs: []int64{},
+		},
 		{
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(NoHitsSearchRs),
+					uri:    "/2/_msearch",
+					rs:     msearchRs(getFixture(TwoHitsSearchRs), getFixture(NoHitsSearchRs), getFixture(ThreeHitsSearchRs)),
 					status: http.StatusOK,
 				},
+			},
+			messages:    []string{"first", "second", "third"},
+			expectedIDs: []int64{1, 2, 3},
+		},
+		{
+			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(ThreeHitsSearchRs),
+					uri:    "/2/_msearch",
+					rs:     msearchRs(getFixture(IndexNotFoundRs), getFixture(OneHitSearchRs)),
 					status: http.StatusOK,
 				},
 			},
-			analyzeRq:     getFixture(LaunchWTestItemsWLogs),
-			expectedIssue: "PB001",
+			messages:    []string{"first", "second"},
+			expectedIDs: []int64{1},
 		},
 		{
 			calls: []ServerCall{
 				{
 					method: "GET",
-					uri:    "/2/_search",
-					rq:     getFixture(SearchRq),
-					rs:     getFixture(TwoHitsSearchRs),
-					status: http.StatusOK,
+					uri:    "/2/_msearch",
+					rs:     getFixture(IndexNotFoundRs),
+					status: http.StatusNotFound,
 				},
 			},
-			analyzeRq:     getFixture(LaunchWTestItemsWLogsDifferentLogLevel),
-			expectedIssue: "AB001",
+			messages:    []string{"first"},
+			expectedIDs: []int64{},
 		},
 	}
 
@@ -486,19 +612,186 @@ func TestAnalyzeLogs(t *testing.T) {
 		i := 0
 		ts := startServer(t, test.calls, &i)
 		defer ts.Close()
-		c := NewClient([]string{ts.URL}, defaultSearchConfig())
-
-		launches := []Launch{}
-		err := json.Unmarshal([]byte(test.analyzeRq), &launches)
-		assert.NoError(t, err)
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
 
-		results, err := c.AnalyzeLogs(launches)
+		ids, err := c.SearchLogs(SearchLogs{ProjectID: 2, ItemID: 1, LogMessages: test.messages, LogLines: -1})
 		assert.NoError(t, err)
+		assert.Equal(t, test.expectedIDs, ids)
+		assert.Equal(t, len(test.calls), i)
+	}
+}
 
-		if test.expectedIssue != "" {
-			assert.Equal(t, test.expectedIssue, results[0].IssueType)
+func Test_predictIssueType(t *testing.T) {
+	hit := func(id string, testItem int64) Hit {
+		h := Hit{ID: id}
+		h.Source.TestItem = testItem
+		return h
+	}
+	issueTypes := func() map[string]*score {
+		return map[string]*score{
+			"AB001": {score: 1.2, mrHit: hit("10", 1)},
+			"PB001": {score: 0.6, mrHit: hit("20", 2)},
+			"SI001": {score: 0.2, mrHit: hit("30", 3)},
 		}
 	}
+
+	tests := []struct {
+		name       string
+		cfg        *SearchConfig
+		issueTypes map[string]*score
+		expected   *AnalysisResult
+	}{
+		{
+			name:       "nothing to predict",
+			cfg:        &SearchConfig{MaxAlternatives: 3},
+			issueTypes: map[string]*score{},
+		},
+		{
+			name:       "with alternatives",
+			cfg:        &SearchConfig{MaxAlternatives: 3},
+			issueTypes: issueTypes(),
+			expected: &AnalysisResult{
+				TestItem:     100,
+				IssueType:    "AB001",
+				RelevantItem: 1,
+				RelevantLog:  10,
+				Score:        0.6,
+				Alternatives: []IssueTypeScore{
+					{IssueType: "PB001", Score: 0.3, RelevantItem: 2, RelevantLog: 20},
+					{IssueType: "SI001", Score: 0.1, RelevantItem: 3, RelevantLog: 30},
+				},
+			},
+		},
+		{
+			name:       "alternatives limited",
+			cfg:        &SearchConfig{MaxAlternatives: 1},
+			issueTypes: issueTypes(),
+			expected: &AnalysisResult{
+				TestItem:     100,
+				IssueType:    "AB001",
+				RelevantItem: 1,
+				RelevantLog:  10,
+				Score:        0.6,
+				Alternatives: []IssueTypeScore{
+					{IssueType: "PB001", Score: 0.3, RelevantItem: 2, RelevantLog: 20},
+				},
+			},
+		},
+		{
+			name:       "confidence is too low",
+			cfg:        &SearchConfig{MaxAlternatives: 3, MinConfidence: 0.7},
+			issueTypes: issueTypes(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rs := predictIssueType(tt.cfg, 100, tt.issueTypes)
+			if nil == tt.expected {
+				assert.Nil(t, rs)
+				return
+			}
+			if assert.NotNil(t, rs) {
+				assert.InDelta(t, tt.expected.Score, rs.Score, 1e-9)
+				for i := range rs.Alternatives {
+					assert.InDelta(t, tt.expected.Alternatives[i].Score, rs.Alternatives[i].Score, 1e-9)
+					rs.Alternatives[i].Score = tt.expected.Alternatives[i].Score
+				}
+				rs.Score = tt.expected.Score
+				assert.Equal(t, tt.expected, rs)
+			}
+		})
+	}
+}
+
+func Test_buildAnalyzeQueryWithException(t *testing.T) {
+	c := newTestClient(t, []string{"http://localhost:9200"}, defaultClientConfig(), defaultSearchConfig()).(*client)
+	launch := Launch{LaunchName: "launch", Conf: AnalyzerConf{Mode: SearchModeAll}}
+
+	q, err := json.Marshal(c.buildAnalyzeQuery(launch, "unique", parsedLog{Message: "Assertion failed"}))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(q), "exception")
+
+	q, err = json.Marshal(c.buildAnalyzeQuery(launch, "unique", parsedLog{
+		Message:          "Login failed java.net.ConnectException: Connection refused",
+		ExceptionType:    "java.net.ConnectException",
+		ExceptionMessage: "Connection refused",
+		Text:             "Login failed",
+	}))
+	assert.NoError(t, err)
+	assert.Contains(t, string(q), `{"term":{"exception_type":{"value":"java.net.ConnectException","boost":2}}}`)
+	assert.Contains(t, string(q), `"fields":["exception_message"],"like":"Connection refused"`)
+	assert.Contains(t, string(q), `"fields":["text"],"like":"Login failed"`)
+	assert.Contains(t, string(q), `"minimum_should_match":1`)
+	assert.NotContains(t, string(q), "stack_trace")
+}
+
+func TestIndexPrefix(t *testing.T) {
+	calls := []ServerCall{
+		{
+			method: "GET",
+			uri:    "/rp_2/_msearch",
+			rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(NoHitsSearchRs)),
+			status: http.StatusOK,
+		},
+		{
+			method: "GET",
+			uri:    "/rp_2/_msearch",
+			rs:     msearchRs(getFixture(NoHitsSearchRs)),
+			status: http.StatusOK,
+		},
+		{
+			method: "POST",
+			uri:    "/_bulk?refresh",
+			rq:     `{"delete":{"_id":1,"_index":"rp_2"}}` + "\n",
+			rs:     `{}`,
+			status: http.StatusOK,
+		},
+		{
+			method: "GET",
+			uri:    "/_alias/rp_2",
+			rs:     `{"rp_2_v2":{"aliases":{"rp_2":{}}}}`,
+			status: http.StatusOK,
+		},
+		{
+			method: "DELETE",
+			uri:    "/rp_2_v2",
+			rs:     getFixture(IndexDeletedRs),
+			status: http.StatusOK,
+		},
+	}
+	i := 0
+	ts := startServer(t, calls, &i)
+	defer ts.Close()
+	cfg := defaultClientConfig()
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	launches := []Launch{}
+	assert.NoError(t, json.Unmarshal([]byte(getFixture(LaunchWTestItemsWLogs)), &launches))
+	_, err := c.AnalyzeLogs(launches)
+	assert.NoError(t, err)
+
+	_, err = c.SearchLogs(SearchLogs{ProjectID: 2, LogMessages: []string{"message"}})
+	assert.NoError(t, err)
+
+	_, err = c.DeleteLogs(&CleanIndex{Project: 2, IDs: []int64{1}})
+	assert.NoError(t, err)
+
+	_, err = c.DeleteIndex(2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(calls), i)
+}
+
+//msearchRq builds multi search request consisting of n queries
+func msearchRq(n int, query string) string {
+	return strings.Repeat("{}\n"+query, n)
+}
+
+//msearchRs builds multi search response from search responses
+func msearchRs(responses ...string) string {
+	return `{"responses":[` + strings.Join(responses, ",") + `]}`
 }
 
 func TestClearIndex(t *testing.T) {
@@ -625,3 +918,20 @@ func defaultSearchConfig() *SearchConfig {
 	}
 	return sc
 }
+
+func defaultClientConfig() *ClientConfig {
+	cc := &ClientConfig{}
+	if err := conf.LoadConfig(cc); err != nil {
+		log.Error(err)
+		return &ClientConfig{}
+	}
+	return cc
+}
+
+func newTestClient(t *testing.T, hosts []string, clientCfg *ClientConfig, searchCfg *SearchConfig) ESClient {
+	c, err := NewClient(hosts, clientCfg, searchCfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+//ErrorCause is a cause of ES error
+type ErrorCause struct {
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+//ErrorDetails is an error section of ES response
+type ErrorDetails struct {
+	RootCause []ErrorCause `json:"root_cause,omitempty"`
+	Type      string       `json:"type,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+}
+
+//ESError is returned when ES responds with non-2xx status
+type ESError struct {
+	Status int
+	ErrorDetails
+}
+
+//newESError parses error details from ES response body
+func newESError(status int, body []byte) *ESError {
+	e := &ESError{Status: status}
+	if len(body) == 0 {
+		return e
+	}
+
+	var rs struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &rs); err != nil || len(rs.Error) == 0 {
+		e.Reason = string(body)
+		return e
+	}
+
+	//old ES versions respond with error as a plain string
+	if err := json.Unmarshal(rs.Error, &e.ErrorDetails); err != nil {
+		var reason string
+		if err := json.Unmarshal(rs.Error, &reason); err != nil {
+			reason = string(rs.Error)
+		}
+		e.Reason = reason
+	}
+	return e
+}
+
+func (e *ESError) Error() string {
+	if "" == e.Type && "" == e.Reason {
+		return fmt.Sprintf("ES responded with status %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("ES responded with status %d: [%s] %s", e.Status, e.Type, e.Reason)
+}
+
+//hasESStatus checks whether error is ESError with one of the provided statuses
+func hasESStatus(err error, statuses ...int) bool {
+	esErr, ok := errors.Cause(err).(*ESError)
+	if !ok {
+		return false
+	}
+	for _, s := range statuses {
+		if esErr.Status == s {
+			return true
+		}
+	}
+	return false
+}
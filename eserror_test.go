@@ -0,0 +1,157 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestNewESError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		errType   string
+		reason    string
+		rootCause int
+	}{
+		{
+			name:      "index not found",
+			status:    http.StatusNotFound,
+			body:      getFixture(IndexNotFoundRs),
+			errType:   "index_not_found_exception",
+			reason:    "no such index",
+			rootCause: 1,
+		},
+		{
+			name:      "index already exists",
+			status:    http.StatusBadRequest,
+			body:      getFixture(IndexAlreadyExistsRs),
+			errType:   "index_already_exists_exception",
+			reason:    "index [idx1/DoA20IojS72IdaFSN8CX9Q] already exists",
+			rootCause: 1,
+		},
+		{
+			name:   "string error",
+			status: http.StatusBadRequest,
+			body:   `{"error":"IndexMissingException[[idx1] missing]","status":404}`,
+			reason: "IndexMissingException[[idx1] missing]",
+		},
+		{
+			name:   "not a json",
+			status: http.StatusBadGateway,
+			body:   "Bad Gateway",
+			reason: "Bad Gateway",
+		},
+		{
+			name:   "empty body",
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := newESError(tt.status, []byte(tt.body))
+			assert.Equal(t, tt.status, err.Status)
+			assert.Equal(t, tt.errType, err.Type)
+			assert.Equal(t, tt.reason, err.Reason)
+			assert.Len(t, err.RootCause, tt.rootCause)
+			assert.NotEmpty(t, err.Error())
+		})
+	}
+}
+
+func TestESErrorStatuses(t *testing.T) {
+	tests := []struct {
+		calls []ServerCall
+		call  func(c ESClient) error
+		check func(err error)
+	}{
+		{
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/idx0",
+					status: http.StatusForbidden,
+				},
+			},
+			call: func(c ESClient) error {
+				_, err := c.IndexExists("idx0")
+				return err
+			},
+			check: func(err error) {
+				assert.True(t, hasESStatus(err, http.StatusForbidden))
+			},
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/2/_msearch",
+					rs:     getFixture(IndexNotFoundRs),
+					status: http.StatusNotFound,
+				},
+			},
+			call: func(c ESClient) error {
+				launches := []Launch{}
+				if err := json.Unmarshal([]byte(getFixture(LaunchWTestItemsWLogs)), &launches); err != nil {
+					return err
+				}
+				rs, err := c.AnalyzeLogs(launches)
+				assert.Empty(t, rs)
+				return err
+			},
+			check: func(err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "PUT",
+					uri:    "/_bulk?refresh",
+					rs:     `{"error":{"type":"illegal_argument_exception","reason":"bad bulk"},"status":400}`,
+					status: http.StatusBadRequest,
+				},
+			},
+			call: func(c ESClient) error {
+				return c.(*client).sendOpRequest(context.Background(), http.MethodPut, c.buildURL("_bulk?refresh"), &BulkResponse{}, map[string]string{})
+			},
+			check: func(err error) {
+				esErr, ok := errors.Cause(err).(*ESError)
+				if assert.True(t, ok) {
+					assert.Equal(t, "illegal_argument_exception", esErr.Type)
+					assert.Equal(t, "bad bulk", esErr.Reason)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		i := 0
+		ts := startServer(t, test.calls, &i)
+		defer ts.Close()
+		c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+
+		test.check(test.call(c))
+		assert.Equal(t, len(test.calls), i)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//esHost is a single node of ES cluster
+type esHost struct {
+	url       string
+	dead      bool
+	deadSince time.Time
+}
+
+//hostPool balances requests across ES nodes in round-robin manner.
+//Dead nodes are excluded from balancing and resurrected once deadTimeout passes
+type hostPool struct {
+	mu          sync.Mutex
+	hosts       []*esHost
+	next        int
+	deadTimeout time.Duration
+	now         func() time.Time
+}
+
+func newHostPool(urls []string, deadTimeout time.Duration) *hostPool {
+	hosts := make([]*esHost, len(urls))
+	for i, u := range urls {
+		hosts[i] = &esHost{url: strings.TrimSuffix(u, "/")}
+	}
+	return &hostPool{hosts: hosts, deadTimeout: deadTimeout, now: time.Now}
+}
+
+//size returns number of configured hosts
+func (p *hostPool) size() int {
+	return len(p.hosts)
+}
+
+//nextHost returns next alive host. If all the hosts are dead
+//they are returned in turn since any of them may have recovered
+func (p *hostPool) nextHost() *esHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.resurrect()
+
+	n := len(p.hosts)
+	for i := 0; i < n; i++ {
+		h := p.hosts[(p.next+i)%n]
+		if !h.dead {
+			p.next = (p.next + i + 1) % n
+			return h
+		}
+	}
+
+	h := p.hosts[p.next]
+	p.next = (p.next + 1) % n
+	return h
+}
+
+//isHostFailure checks whether response means the node itself is not operational. Other errors
+//such as query or shard failures are reported by any node the same way so the node is not blamed for them
+func isHostFailure(status int, err error) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return err != nil
+}
+
+//markDead excludes host from balancing
+func (p *hostPool) markDead(h *esHost) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !h.dead {
+		log.Warnf("ES host %s is marked as dead", h.url)
+		h.dead = true
+		h.deadSince = p.now()
+	}
+}
+
+//markAlive returns host back to balancing
+func (p *hostPool) markAlive(h *esHost) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if h.dead {
+		log.Infof("ES host %s is alive again", h.url)
+		h.dead = false
+	}
+}
+
+//resurrect gives dead hosts another chance once dead timeout passes.
+//Should be called under lock
+func (p *hostPool) resurrect() {
+	now := p.now()
+	for _, h := range p.hosts {
+		if h.dead && now.Sub(h.deadSince) >= p.deadTimeout {
+			log.Infof("Resurrecting ES host %s", h.url)
+			h.dead = false
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHostPoolRoundRobin(t *testing.T) {
+	p := newHostPool([]string{"http://es1:9200/", "http://es2:9200", "http://es3:9200"}, time.Minute)
+
+	assert.Equal(t, "http://es1:9200", p.nextHost().url)
+	assert.Equal(t, "http://es2:9200", p.nextHost().url)
+	assert.Equal(t, "http://es3:9200", p.nextHost().url)
+	assert.Equal(t, "http://es1:9200", p.nextHost().url)
+}
+
+func TestHostPoolDeadHosts(t *testing.T) {
+	now := time.Now()
+	p := newHostPool([]string{"http://es1:9200", "http://es2:9200"}, time.Minute)
+	p.now = func() time.Time { return now }
+
+	p.markDead(p.hosts[0])
+	assert.Equal(t, "http://es2:9200", p.nextHost().url)
+	assert.Equal(t, "http://es2:9200", p.nextHost().url)
+
+	//all the hosts are dead, they are tried in turn
+	now = now.Add(time.Second)
+	p.markDead(p.hosts[1])
+	assert.Equal(t, "http://es1:9200", p.nextHost().url)
+	assert.Equal(t, "http://es2:9200", p.nextHost().url)
+
+	//dead timeout has passed
+	now = now.Add(time.Minute)
+	assert.Equal(t, "http://es1:9200", p.nextHost().url)
+	assert.Equal(t, "http://es2:9200", p.nextHost().url)
+	assert.False(t, p.hosts[0].dead)
+	assert.False(t, p.hosts[1].dead)
+}
+
+func TestClientFailover(t *testing.T) {
+	i := 0
+	failed := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			status: http.StatusServiceUnavailable,
+		},
+	}, &i)
+	defer failed.Close()
+
+	j := 0
+	healthy := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			rs:     getFixture(TwoIndicesRs),
+			status: http.StatusOK,
+		},
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			rs:     getFixture(TwoIndicesRs),
+			status: http.StatusOK,
+		},
+	}, &j)
+	defer healthy.Close()
+
+	cfg := defaultClientConfig()
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{failed.URL, healthy.URL}, cfg, defaultSearchConfig())
+
+	indices, err := c.ListIndices()
+	assert.NoError(t, err)
+	assert.Len(t, indices, 2)
+
+	//failed host is marked as dead and is not called anymore
+	_, err = c.ListIndices()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, i)
+	assert.Equal(t, 2, j)
+}
+
+func TestClientDoesNotFailOverOnServerError(t *testing.T) {
+	i := 0
+	failed := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			status: http.StatusInternalServerError,
+		},
+	}, &i)
+	defer failed.Close()
+
+	j := 0
+	healthy := startServer(t, []ServerCall{}, &j)
+	defer healthy.Close()
+
+	cfg := defaultClientConfig()
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{failed.URL, healthy.URL}, cfg, defaultSearchConfig())
+
+	//query failure is reported by any node the same way so it's neither retried on other node nor blames the node
+	_, err := c.ListIndices()
+	assert.Error(t, err)
+	assert.Equal(t, 1, i)
+	assert.Equal(t, 0, j)
+	assert.False(t, c.(*client).hosts.hosts[0].dead)
+}
@@ -0,0 +1,350 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//indexVersion is a version of the current index template. Must be increased once mapping or settings are changed.
+//Indices created before versioning are considered to be of version 1
+const indexVersion = 2
+
+//ErrNoIndexPrefix means indices of the analyzer cannot be listed since they cannot be told apart
+//from indices of other applications sharing the cluster
+var ErrNoIndexPrefix = errors.New("Indices cannot be listed without ES_INDEX_PREFIX configured")
+
+//versionedIndexRe matches name of versioned index such as '12_v2'
+var versionedIndexRe = regexp.MustCompile(`^(.+)_v(\d+)$`)
+
+//indexTemplate builds settings and mappings of the current index version
+func indexTemplate() map[string]interface{} {
+	return map[string]interface{}{
+		"settings": map[string]interface{}{
+			"number_of_shards": 1,
+			"analysis": map[string]interface{}{
+				"analyzer": map[string]interface{}{
+					"standard_english_analyzer": map[string]interface{}{
+						"type":      "standard",
+						"stopwords": "_english_",
+					},
+				},
+			},
+		},
+		"mappings": map[string]interface{}{
+			"_meta": map[string]interface{}{
+				"version": indexVersion,
+			},
+			"properties": map[string]interface{}{
+				"test_item": map[string]interface{}{
+					"type": "keyword",
+				},
+				"issue_type": map[string]interface{}{
+					"type": "keyword",
+				},
+				"message": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "standard_english_analyzer",
+				},
+				"log_level": map[string]interface{}{
+					"type": "integer",
+				},
+				"launch_name": map[string]interface{}{
+					"type": "keyword",
+				},
+				"unique_id": map[string]interface{}{
+					"type": "keyword",
+				},
+				"is_auto_analyzed": map[string]interface{}{
+					"type": "keyword",
+				},
+				"log_time": map[string]interface{}{
+					"type": "date",
+				},
+				"exception_type": map[string]interface{}{
+					"type": "keyword",
+				},
+				"exception_message": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "standard_english_analyzer",
+				},
+				"stack_trace": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "standard_english_analyzer",
+				},
+				"text": map[string]interface{}{
+					"type":     "text",
+					"analyzer": "standard_english_analyzer",
+				},
+			},
+		},
+	}
+}
+
+//warnOutdatedIndices warns about indices created with mapping of the previous versions. Exception type is not matched
+//within them since the field is not a keyword there. Indices cannot be listed without prefix so migration is only advised then
+func warnOutdatedIndices(c ESClient) {
+	indices, err := c.ListIndices()
+	if ErrNoIndexPrefix == errors.Cause(err) {
+		log.Warnf("Versions of indices cannot be checked without ES_INDEX_PREFIX configured. "+
+			"Indices created before version %d are to be migrated by 'migrate <project...>' command", indexVersion)
+		return
+	}
+	if nil != err {
+		log.Warnf("Cannot check versions of indices: %v", err)
+		return
+	}
+	if outdated := outdatedIndices(indices); len(outdated) > 0 {
+		log.Warnf("Indices %s are of version older than %d. Exception type is not matched within them until they are migrated by 'migrate' command",
+			strings.Join(outdated, ", "), indexVersion)
+	}
+}
+
+//outdatedIndices selects indices created with mapping of the previous versions
+func outdatedIndices(indices []Index) []string {
+	var outdated []string
+	for _, idx := range indices {
+		if _, version := parseVersionedIndex(idx.Index); version < indexVersion {
+			outdated = append(outdated, idx.Index)
+		}
+	}
+	sort.Strings(outdated)
+	return outdated
+}
+
+//versionedIndex builds name of the index of provided version which is addressed through the alias
+func versionedIndex(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+//parseVersionedIndex obtains alias and version of the index
+func parseVersionedIndex(name string) (alias string, version int) {
+	if m := versionedIndexRe.FindStringSubmatch(name); nil != m {
+		if v, err := strconv.Atoi(m[2]); nil == err {
+			return m[1], v
+		}
+	}
+	return name, 1
+}
+
+//resolveAlias obtains indices the alias points to
+//Name is returned as is if there is no such alias since it might be index created before versioning
+func (c *client) resolveAlias(ctx context.Context, alias string) ([]string, error) {
+	rs := map[string]interface{}{}
+	err := c.sendOpRequest(ctx, http.MethodGet, c.buildURL("_alias", alias), &rs)
+	if hasESStatus(err, http.StatusNotFound) {
+		return []string{alias}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	indices := make([]string, 0, len(rs))
+	for idx := range rs {
+		indices = append(indices, idx)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+//indexName builds name of the project index as it's addressed by the analyzer
+func (c *client) indexName(project int64) string {
+	return c.clientCfg.IndexPrefix + strconv.FormatInt(project, 10)
+}
+
+//parseIndexName obtains project of the index. Returns FALSE if index is not owned by the analyzer
+func (c *client) parseIndexName(name string) (int64, bool) {
+	if !strings.HasPrefix(name, c.clientCfg.IndexPrefix) {
+		return 0, false
+	}
+	alias, _ := parseVersionedIndex(name)
+	project, err := strconv.ParseInt(strings.TrimPrefix(alias, c.clientCfg.IndexPrefix), 10, 64)
+	if nil != err {
+		return 0, false
+	}
+	return project, true
+}
+
+//ListProjects lists projects having index
+func (c *client) ListProjects() ([]int64, error) {
+	return c.ListProjectsContext(context.Background())
+}
+
+//ListProjectsContext lists projects having index
+func (c *client) ListProjectsContext(ctx context.Context) ([]int64, error) {
+	indices, err := c.ListIndicesContext(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	set := map[int64]bool{}
+	projects := []int64{}
+	for _, idx := range indices {
+		project, ok := c.parseIndexName(idx.Index)
+		if !ok || set[project] {
+			continue
+		}
+		set[project] = true
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i] < projects[j] })
+	return projects, nil
+}
+
+//MigrateIndex moves project index of outdated version to the current one. Creates new index, blocks writes to the old one,
+//reindexes its documents, swaps alias and removes the old index. Index without prefix is moved to the prefixed one
+//if prefix is configured after the index has been created
+func (c *client) MigrateIndex(project int64) error {
+	return c.MigrateIndexContext(context.Background(), project)
+}
+
+//MigrateIndexContext moves project index of outdated version to the current one
+func (c *client) MigrateIndexContext(ctx context.Context, project int64) error {
+	ctx, cancel := withTimeout(withOperation(ctx, "migrate_index"), c.clientCfg.MigrateTimeout)
+	defer cancel()
+
+	name := c.indexName(project)
+	source, err := c.migrationSource(ctx, project)
+	if err != nil {
+		return err
+	}
+	if "" == source {
+		log.Infof("Index %s does not exist. Nothing to migrate", name)
+		return nil
+	}
+
+	indices, err := c.resolveAlias(ctx, source)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot resolve alias %s", source)
+	}
+	if len(indices) != 1 {
+		return errors.Errorf("Alias %s points to %d indices. Expected one", source, len(indices))
+	}
+	current := indices[0]
+	_, version := parseVersionedIndex(current)
+	if version >= indexVersion && source == name {
+		log.Infof("Index %s is up to date", current)
+		return nil
+	}
+
+	target := versionedIndex(name, indexVersion)
+	log.Infof("Migrating index %s to %s", current, target)
+
+	//target might be left by the interrupted migration
+	targetExists, err := c.IndexExistsContext(ctx, target)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot check index %s exists", target)
+	}
+	if !targetExists {
+		if err := c.sendOpRequest(ctx, http.MethodPut, c.buildURL(target), &Response{}, indexTemplate()); err != nil {
+			return errors.Wrapf(err, "Cannot create index %s", target)
+		}
+	}
+
+	//writes arriving through the alias while documents are reindexed would be lost along with the old index
+	//so they are rejected and retried by the caller once alias points to the new one
+	if err := c.blockWrites(ctx, current, true); err != nil {
+		return errors.Wrapf(err, "Cannot block writes to %s", current)
+	}
+
+	if err := c.reindex(ctx, current, target); err != nil {
+		c.unblockWrites(current)
+		return errors.Wrapf(err, "Cannot reindex %s to %s", current, target)
+	}
+
+	if err := c.swapAlias(ctx, name, current, target); err != nil {
+		c.unblockWrites(current)
+		return errors.Wrapf(err, "Cannot swap alias %s to %s", name, target)
+	}
+	log.Infof("Index %s has been migrated to %s", current, target)
+	return nil
+}
+
+//migrationSource obtains index or alias logs of the project are migrated from. Index created before the prefix
+//has been configured is named by the project only, so it's moved to the prefixed one unless that already exists.
+//Returns empty string if project has no index
+func (c *client) migrationSource(ctx context.Context, project int64) (string, error) {
+	names := []string{c.indexName(project)}
+	if "" != c.clientCfg.IndexPrefix {
+		names = append(names, strconv.FormatInt(project, 10))
+	}
+	for _, name := range names {
+		exists, err := c.IndexExistsContext(ctx, name)
+		if err != nil {
+			return "", errors.Wrapf(err, "Cannot check index %s exists", name)
+		}
+		if exists {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+//blockWrites sets or removes write block of the index
+func (c *client) blockWrites(ctx context.Context, index string, block bool) error {
+	body := map[string]interface{}{"index.blocks.write": block}
+	return c.sendOpRequest(ctx, http.MethodPut, c.buildURL(index, "_settings"), &Response{}, body)
+}
+
+//unblockWrites returns failed migration source back to service. It's done even if migration has timed out
+func (c *client) unblockWrites(index string) {
+	ctx, cancel := withTimeout(withOperation(context.Background(), "migrate_index"), c.clientCfg.RequestTimeout)
+	defer cancel()
+	if err := c.blockWrites(ctx, index, false); err != nil {
+		log.Errorf("Index %s is left blocked for writes: %v", index, err)
+	}
+}
+
+//reindexResponse is a result of reindex operation
+type reindexResponse struct {
+	Total    int           `json:"total,omitempty"`
+	Created  int           `json:"created,omitempty"`
+	Updated  int           `json:"updated,omitempty"`
+	Failures []interface{} `json:"failures,omitempty"`
+}
+
+func (c *client) reindex(ctx context.Context, source, dest string) error {
+	body := map[string]interface{}{
+		"source": map[string]interface{}{"index": source},
+		"dest":   map[string]interface{}{"index": dest},
+	}
+	rs := &reindexResponse{}
+	if err := c.sendOpRequest(ctx, http.MethodPost, c.buildURL("_reindex?wait_for_completion=true&refresh"), rs, body); err != nil {
+		return err
+	}
+	if len(rs.Failures) > 0 {
+		return errors.Errorf("%d documents have not been reindexed: %v", len(rs.Failures), rs.Failures[0])
+	}
+	log.Infof("%d documents have been reindexed from %s to %s", rs.Total, source, dest)
+	return nil
+}
+
+//swapAlias atomically points alias to the target index and removes the old one
+func (c *client) swapAlias(ctx context.Context, alias, old, target string) error {
+	actions := []interface{}{
+		map[string]interface{}{"add": map[string]interface{}{"index": target, "alias": alias}},
+		//index created before versioning has the same name as the alias so it's removed within the same request
+		map[string]interface{}{"remove_index": map[string]interface{}{"index": old}},
+	}
+	return c.sendOpRequest(ctx, http.MethodPost, c.buildURL("_aliases"), &Response{}, map[string]interface{}{"actions": actions})
+}
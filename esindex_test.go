@@ -0,0 +1,370 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func Test_parseVersionedIndex(t *testing.T) {
+	alias, version := parseVersionedIndex("12_v3")
+	assert.Equal(t, "12", alias)
+	assert.Equal(t, 3, version)
+
+	alias, version = parseVersionedIndex("12")
+	assert.Equal(t, "12", alias)
+	assert.Equal(t, 1, version)
+
+	assert.Equal(t, "12_v2", versionedIndex("12", 2))
+}
+
+func Test_outdatedIndices(t *testing.T) {
+	assert.Equal(t, []string{"rp_1_v1", "rp_3"}, outdatedIndices([]Index{{Index: "rp_3"}, {Index: "rp_2_v2"}, {Index: "rp_1_v1"}}))
+	assert.Nil(t, outdatedIndices([]Index{{Index: "rp_2_v2"}}))
+}
+
+func TestMigrateIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		calls     []ServerCall
+		expectErr bool
+	}{
+		{
+			name: "not existing",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusNotFound,
+				},
+			},
+		},
+		{
+			name: "up to date",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusOK,
+				},
+				{
+					method: "GET",
+					uri:    "/_alias/2",
+					rs:     `{"2_v2":{"aliases":{"2":{}}}}`,
+					status: http.StatusOK,
+				},
+			},
+		},
+		{
+			name: "created before versioning",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusOK,
+				},
+				{
+					method: "GET",
+					uri:    "/_alias/2",
+					rs:     `{"error":"alias [2] missing","status":404}`,
+					status: http.StatusNotFound,
+				},
+				{
+					method: "HEAD",
+					uri:    "/2_v2",
+					status: http.StatusNotFound,
+				},
+				{
+					method: "PUT",
+					uri:    "/2_v2",
+					rs:     getFixture(IndexCreatedRs),
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/2/_settings",
+					rq:     `{"index.blocks.write":true}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "POST",
+					uri:    "/_reindex?wait_for_completion=true&refresh",
+					rq:     `{"dest":{"index":"2_v2"},"source":{"index":"2"}}` + "\n",
+					rs:     `{"took":10,"total":2,"created":2,"failures":[]}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "POST",
+					uri:    "/_aliases",
+					rq:     `{"actions":[{"add":{"alias":"2","index":"2_v2"}},{"remove_index":{"index":"2"}}]}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+			},
+		},
+		{
+			name:   "not existing with prefix",
+			prefix: "rp_",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/rp_2",
+					status: http.StatusNotFound,
+				},
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusNotFound,
+				},
+			},
+		},
+		{
+			name:   "created before prefix",
+			prefix: "rp_",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/rp_2",
+					status: http.StatusNotFound,
+				},
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusOK,
+				},
+				{
+					method: "GET",
+					uri:    "/_alias/2",
+					rs:     `{"2_v2":{"aliases":{"2":{}}}}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "HEAD",
+					uri:    "/rp_2_v2",
+					status: http.StatusNotFound,
+				},
+				{
+					method: "PUT",
+					uri:    "/rp_2_v2",
+					rs:     getFixture(IndexCreatedRs),
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/2_v2/_settings",
+					rq:     `{"index.blocks.write":true}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "POST",
+					uri:    "/_reindex?wait_for_completion=true&refresh",
+					rq:     `{"dest":{"index":"rp_2_v2"},"source":{"index":"2_v2"}}` + "\n",
+					rs:     `{"took":10,"total":2,"created":2,"failures":[]}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "POST",
+					uri:    "/_aliases",
+					rq:     `{"actions":[{"add":{"alias":"rp_2","index":"rp_2_v2"}},{"remove_index":{"index":"2_v2"}}]}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+			},
+		},
+		{
+			name: "reindex failures",
+			calls: []ServerCall{
+				{
+					method: "HEAD",
+					uri:    "/2",
+					status: http.StatusOK,
+				},
+				{
+					method: "GET",
+					uri:    "/_alias/2",
+					rs:     `{"2_v1":{"aliases":{"2":{}}}}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "HEAD",
+					uri:    "/2_v2",
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/2_v1/_settings",
+					rq:     `{"index.blocks.write":true}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "POST",
+					uri:    "/_reindex?wait_for_completion=true&refresh",
+					rs:     `{"took":10,"total":2,"created":1,"failures":[{"id":"1","cause":{"type":"mapper_parsing_exception"}}]}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "PUT",
+					uri:    "/2_v1/_settings",
+					rq:     `{"index.blocks.write":false}` + "\n",
+					rs:     `{"acknowledged":true}`,
+					status: http.StatusOK,
+				},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			i := 0
+			ts := startServer(t, tt.calls, &i)
+			defer ts.Close()
+			cfg := defaultClientConfig()
+			cfg.IndexPrefix = tt.prefix
+			c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+			err := c.MigrateIndex(2)
+			assert.Equal(t, tt.expectErr, nil != err)
+			assert.Equal(t, len(tt.calls), i)
+		})
+	}
+}
+
+func TestIndexDuringMigration(t *testing.T) {
+	var mu sync.Mutex
+	blocked, migrated := false, false
+	reindexing := make(chan struct{})
+	indexed := make(chan struct{})
+
+	//ES rejects writes to the blocked index until alias is swapped to the new one
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs := ""
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "HEAD /2":
+		case "GET /_alias/2":
+			rs = `{"2_v1":{"aliases":{"2":{}}}}`
+		case "HEAD /2_v2":
+			w.WriteHeader(http.StatusNotFound)
+		case "PUT /2_v2":
+			rs = getFixture(IndexCreatedRs)
+		case "PUT /2_v1/_settings":
+			mu.Lock()
+			blocked = true
+			mu.Unlock()
+			rs = `{"acknowledged":true}`
+		case "POST /_reindex?wait_for_completion=true&refresh":
+			close(reindexing)
+			<-indexed
+			rs = `{"took":10,"total":2,"created":2,"failures":[]}`
+		case "POST /_aliases":
+			mu.Lock()
+			migrated = true
+			mu.Unlock()
+			rs = `{"acknowledged":true}`
+		case "PUT /_bulk?refresh":
+			mu.Lock()
+			if blocked && !migrated {
+				blockErr := `{"type":"cluster_block_exception","reason":"index [2_v1] blocked by: [FORBIDDEN/8/index write (api)];"}`
+				rs = bulkRs(true, bulkItemRs("1", http.StatusForbidden, blockErr), bulkItemRs("2", http.StatusForbidden, blockErr))
+			} else {
+				rs = bulkRs(false, bulkItemRs("1", http.StatusCreated, ""), bulkItemRs("2", http.StatusCreated, ""))
+			}
+			mu.Unlock()
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.RequestURI())
+		}
+		if _, wErr := w.Write([]byte(rs)); wErr != nil {
+			log.Error(wErr)
+		}
+	}))
+	defer ts.Close()
+	c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+	launches := parseLaunchesFixture(t, getFixture(LaunchWTestItemsWLogs))
+
+	done := make(chan error)
+	go func() {
+		done <- c.MigrateIndex(2)
+	}()
+	<-reindexing
+
+	//logs written to the old index would be lost along with it so they are rejected to be indexed once again
+	_, err := c.IndexLogs(launches)
+	assert.Equal(t, ErrIndexBlocked, errors.Cause(err))
+	close(indexed)
+	assert.NoError(t, <-done)
+
+	rs, err := c.IndexLogs(launches)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rs.Summary.Indexed)
+}
+
+func TestDeleteLogsDuringMigration(t *testing.T) {
+	blockErr := `{"type":"cluster_block_exception","reason":"index [2_v1] blocked by: [FORBIDDEN/8/index write (api)];"}`
+	i := 0
+	ts := startServer(t, []ServerCall{
+		{
+			method: "POST",
+			uri:    "/_bulk?refresh",
+			rq:     `{"delete":{"_id":1,"_index":"2"}}` + "\n" + `{"delete":{"_id":2,"_index":"2"}}` + "\n",
+			rs: `{"took":1,"errors":true,"items":[{"delete":{"_index":"2_v1","_id":"1","status":403,"error":` + blockErr + `}},` +
+				`{"delete":{"_index":"2_v1","_id":"2","status":403,"error":` + blockErr + `}}]}`,
+			status: http.StatusOK,
+		},
+	}, &i)
+	defer ts.Close()
+	c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+
+	//deleted logs would be moved to the new index otherwise
+	_, err := c.DeleteLogs(&CleanIndex{Project: 2, IDs: []int64{1, 2}})
+	assert.Equal(t, ErrIndexBlocked, errors.Cause(err))
+	assert.Equal(t, 1, i)
+}
+
+func TestListProjects(t *testing.T) {
+	i := 0
+	ts := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			rs:     `[{"index":"rp_2_v2"},{"index":"rp_3"},{"index":".kibana"},{"index":"4"},{"index":"rp_2_v1"}]`,
+			status: http.StatusOK,
+		},
+	}, &i)
+	defer ts.Close()
+	cfg := defaultClientConfig()
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	projects, err := c.ListProjects()
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, projects)
+
+	//indices of other applications would be taken for projects without prefix
+	cfg.IndexPrefix = ""
+	_, err = c.ListProjects()
+	assert.Equal(t, ErrNoIndexPrefix, errors.Cause(err))
+	assert.Equal(t, 1, i)
+}
@@ -17,8 +17,9 @@ package main
 
 //EsQueryRQ is a query model
 type EsQueryRQ struct {
-	Size  int      `json:"size,omitempty"`
-	Query *EsQuery `json:"query,omitempty"`
+	Size    int      `json:"size,omitempty"`
+	Explain bool     `json:"explain,omitempty"`
+	Query   *EsQuery `json:"query,omitempty"`
 }
 
 //EsQuery is a query model
@@ -28,9 +29,10 @@ type EsQuery struct {
 
 //BoolCondition is a bool condition model
 type BoolCondition struct {
-	MustNot *Condition  `json:"must_not,omitempty"`
-	Must    []Condition `json:"must,omitempty"`
-	Should  []Condition `json:"should,omitempty"`
+	MustNot            *Condition  `json:"must_not,omitempty"`
+	Must               []Condition `json:"must,omitempty"`
+	Should             []Condition `json:"should,omitempty"`
+	MinimumShouldMatch int         `json:"minimum_should_match,omitempty"`
 }
 
 //Condition is a condition model
@@ -41,6 +43,7 @@ type Condition struct {
 	Range        map[string]interface{}   `json:"range,omitempty"`
 	Exists       *ExistsCondition         `json:"exists,omitempty"`
 	MoreLikeThis *MoreLikeThisCondition   `json:"more_like_this,omitempty"`
+	Bool         *BoolCondition           `json:"bool,omitempty"`
 }
 
 //ExistsCondition is a exists condition model
@@ -56,6 +59,7 @@ type MoreLikeThisCondition struct {
 	MinTermFreq    float64  `json:"min_term_freq,omitempty"`
 	MinShouldMatch string   `json:"minimum_should_match,omitempty"`
 	MaxQueryTerms  float64  `json:"max_query_terms,omitempty"`
+	Boost          *Boost   `json:"boost,omitempty"`
 }
 
 //TermCondition is a term condition model
@@ -65,6 +69,7 @@ type TermCondition struct {
 }
 
 //RangeCondition is a term condition model
+//
 //nolint:unused,deadcode
 type RangeCondition struct {
 	Value map[string]interface{} `json:"value,omitempty"`
@@ -37,7 +37,7 @@ var _ = Describe("ES Query Struct", func() {
 
 		c := &client{searchCfg: cfg}
 		launch := Launch{Conf: AnalyzerConf{Mode: SearchModeAll}, LaunchID: 123, LaunchName: "Launch name"}
-		q1Struct := c.buildAnalyzeQuery(launch, "unique", "hello world")
+		q1Struct := c.buildAnalyzeQuery(launch, "unique", parsedLog{Message: "hello world"})
 		q2Struct := buildDemoQuery(cfg, SearchModeAll, "mylaynch", "unique", "hello world")
 
 		q1B, _ := json.Marshal(q1Struct)
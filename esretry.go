@@ -0,0 +1,148 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/pkg/errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//ErrCircuitOpen is returned when ES requests are not sent since ES is considered unavailable
+var ErrCircuitOpen = errors.New("ES is unavailable: circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+//circuitBreaker stops sending requests to ES once threshold of consecutive failures is reached.
+//After timeout passes single trial request is let through. Its result decides whether breaker closes or opens again
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	timeout   time.Duration
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, timeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, timeout: timeout, now: time.Now}
+}
+
+//allow returns TRUE if request may be sent. Trial is TRUE if request is the trial one of half-open breaker
+func (b *circuitBreaker) allow() (allowed, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.timeout {
+			return false, false
+		}
+		log.Info("ES circuit breaker is half-open. Sending trial request")
+		b.state = breakerHalfOpen
+		return true, true
+	case breakerHalfOpen:
+		//trial request is in progress
+		return false, false
+	default:
+		return true, false
+	}
+}
+
+//release gives up trial request abandoned without result so the next request is let through as a trial one.
+//Otherwise breaker would stay half-open rejecting all the requests
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if breakerHalfOpen == b.state {
+		b.state = breakerOpen
+	}
+}
+
+//record registers result of the request
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if breakerClosed != b.state {
+			log.Info("ES circuit breaker is closed")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if breakerHalfOpen == b.state || (b.threshold > 0 && b.failures >= b.threshold) {
+		if breakerOpen != b.state {
+			log.Warnf("ES circuit breaker is open after %d failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+//isOpen returns TRUE if requests are not let through
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return breakerOpen == b.state && b.now().Sub(b.openedAt) < b.timeout
+}
+
+//isRetryable checks whether failed request may be sent once again
+func isRetryable(method string, status int, err error) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	if !isIdempotent(method) {
+		return false
+	}
+	return err != nil || http.StatusBadGateway == status || http.StatusGatewayTimeout == status
+}
+
+//isIdempotent checks whether request may be safely repeated. Search requests are sent via GET
+func isIdempotent(method string) bool {
+	return http.MethodGet == method || http.MethodHead == method
+}
+
+//isFailure checks whether response means ES is not operational
+func isFailure(status int, err error) bool {
+	return err != nil || status >= http.StatusInternalServerError
+}
+
+//backoff calculates exponential delay with full jitter for the given attempt
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
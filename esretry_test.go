@@ -0,0 +1,262 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		calls     []ServerCall
+		expectErr bool
+	}{
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					status: http.StatusServiceUnavailable,
+				},
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					status: http.StatusTooManyRequests,
+				},
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					rs:     getFixture(TwoIndicesRs),
+					status: http.StatusOK,
+				},
+			},
+			expectErr: false,
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					status: http.StatusServiceUnavailable,
+				},
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					status: http.StatusServiceUnavailable,
+				},
+				{
+					method: "GET",
+					uri:    "/_cat/indices?format=json",
+					status: http.StatusServiceUnavailable,
+				},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		i := 0
+		ts := startServer(t, test.calls, &i)
+		defer ts.Close()
+
+		cfg := defaultClientConfig()
+		cfg.MaxRetries = 2
+		cfg.RetryBackoff = time.Millisecond
+		cfg.IndexPrefix = "rp_"
+		c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+		_, err := c.ListIndices()
+
+		assert.Equal(t, len(test.calls), i)
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, allowed(b))
+	b.record(false)
+	assert.False(t, b.isOpen())
+	b.record(false)
+	assert.True(t, b.isOpen())
+	assert.False(t, allowed(b))
+
+	//single trial request is allowed after timeout
+	now = now.Add(time.Minute)
+	assert.True(t, allowed(b))
+	assert.False(t, allowed(b))
+	b.record(false)
+	assert.True(t, b.isOpen())
+
+	now = now.Add(time.Minute)
+	assert.True(t, allowed(b))
+	b.record(true)
+	assert.False(t, b.isOpen())
+	assert.True(t, allowed(b))
+}
+
+func allowed(b *circuitBreaker) bool {
+	ok, _ := b.allow()
+	return ok
+}
+
+func TestClientFailsFastWhenBreakerIsOpen(t *testing.T) {
+	i := 0
+	ts := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/_cat/indices?format=json",
+			status: http.StatusInternalServerError,
+		},
+	}, &i)
+	defer ts.Close()
+
+	cfg := defaultClientConfig()
+	cfg.BreakerThreshold = 1
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	_, err := c.ListIndices()
+	assert.Error(t, err)
+
+	_, err = c.ListIndices()
+	assert.Equal(t, ErrCircuitOpen, errors.Cause(err))
+	assert.False(t, c.Healthy())
+	assert.Equal(t, 1, i)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		select {
+		case <-release:
+		case <-rq.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	cfg := defaultClientConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	cfg.BreakerThreshold = 1
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	start := time.Now()
+	_, err := c.ListIndices()
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	assert.True(t, time.Since(start) < time.Second)
+
+	//timed out request is neither retried on another host nor counted by circuit breaker
+	cl := c.(*client)
+	assert.False(t, cl.breaker.isOpen())
+	assert.False(t, cl.hosts.hosts[0].dead)
+}
+
+func TestAbandonedTrialRequest(t *testing.T) {
+	release := make(chan struct{})
+	calls := int32(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		//breaker is opened by the first request while trial one times out
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-release:
+		case <-rq.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	cfg := defaultClientConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	cfg.BreakerThreshold = 1
+	cfg.BreakerTimeout = time.Minute
+	cfg.MaxRetries = 0
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+	cl := c.(*client)
+	now := time.Now()
+	cl.breaker.now = func() time.Time { return now }
+
+	_, err := c.ListIndices()
+	assert.Error(t, err)
+	assert.True(t, cl.breaker.isOpen())
+
+	now = now.Add(time.Minute)
+	_, err = c.ListIndices()
+	assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	//abandoned trial says nothing about cluster health so the next request is a trial one
+	ok, trial := cl.breaker.allow()
+	assert.True(t, ok)
+	assert.True(t, trial)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCancelledRequest(t *testing.T) {
+	i := 0
+	ts := startServer(t, []ServerCall{}, &i)
+	defer ts.Close()
+
+	cfg := defaultClientConfig()
+	cfg.IndexPrefix = "rp_"
+	c := newTestClient(t, []string{ts.URL}, cfg, defaultSearchConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.AnalyzeLogsContext(ctx, []Launch{{LaunchID: 1, Project: 2, TestItems: []TestItem{{TestItemID: 3}}}})
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	_, err = c.ListIndicesContext(ctx)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, 0, i)
+}
+
+func Test_isRetryable(t *testing.T) {
+	assert.True(t, isRetryable(http.MethodPut, http.StatusTooManyRequests, nil))
+	assert.True(t, isRetryable(http.MethodPost, http.StatusServiceUnavailable, nil))
+	assert.True(t, isRetryable(http.MethodGet, 0, errors.New("connection refused")))
+	assert.True(t, isRetryable(http.MethodHead, http.StatusGatewayTimeout, nil))
+	assert.False(t, isRetryable(http.MethodPost, 0, errors.New("connection refused")))
+	assert.False(t, isRetryable(http.MethodGet, http.StatusInternalServerError, nil))
+	assert.False(t, isRetryable(http.MethodGet, http.StatusNotFound, nil))
+}
+
+func Test_backoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt, 100*time.Millisecond, time.Second)
+		assert.True(t, d >= 0)
+		assert.True(t, d <= time.Second)
+		assert.True(t, d <= 100*time.Millisecond<<uint(attempt))
+	}
+}
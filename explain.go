@@ -0,0 +1,120 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+//weightRe matches ES explanation of term weight such as 'weight(message:exception in 12) [PerFieldSimilarity]'
+var weightRe = regexp.MustCompile(`weight\(([\w.]+):(\S+) in `)
+
+//Explanation describes how prediction has been made
+//Reason tells why issue type has not been predicted if so
+type Explanation struct {
+	Reason     string           `json:"reason,omitempty"`
+	Queries    []interface{}    `json:"queries,omitempty"`
+	Hits       []ExplainedHit   `json:"hits,omitempty"`
+	IssueTypes []IssueTypeScore `json:"issueTypes,omitempty"`
+}
+
+//ExplainedHit is a hit which has been taken into account by prediction
+//Query is an index of the query hit is found by
+type ExplainedHit struct {
+	Query        int           `json:"query"`
+	LogID        int64         `json:"logId,omitempty"`
+	TestItem     int64         `json:"testItem,omitempty"`
+	LaunchName   string        `json:"launchName,omitempty"`
+	IssueType    string        `json:"issueType,omitempty"`
+	Score        float64       `json:"score,omitempty"`
+	MatchedTerms []MatchedTerm `json:"matchedTerms,omitempty"`
+}
+
+//MatchedTerm is a term of the field which contributed to the hit score
+type MatchedTerm struct {
+	Field string `json:"field"`
+	Term  string `json:"term"`
+}
+
+//HitExplanation is an ES explanation of hit score
+type HitExplanation struct {
+	Value       float64          `json:"value,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Details     []HitExplanation `json:"details,omitempty"`
+}
+
+//explain builds explanation of prediction out of queries and their results
+//k is number of top hits of each result taken into account
+func explain(queries []interface{}, results []*SearchResult, k int, issueTypes map[string]*score) *Explanation {
+	e := &Explanation{Queries: queries, IssueTypes: rankIssueTypes(issueTypes)}
+	for i, rs := range results {
+		for _, h := range topHits(rs, k) {
+			e.Hits = append(e.Hits, ExplainedHit{
+				Query:        i,
+				LogID:        relevantLog(h),
+				TestItem:     h.Source.TestItem,
+				LaunchName:   h.Source.LaunchName,
+				IssueType:    h.Source.IssueType,
+				Score:        h.Score,
+				MatchedTerms: matchedTerms(h.Explanation),
+			})
+		}
+	}
+	return e
+}
+
+//unpredicted builds result of the test item issue type has not been predicted for.
+//Such result is reported only if explanation is requested so candidates rejected by confidence can be examined
+func unpredicted(cfg *SearchConfig, testItem int64, e *Explanation) *AnalysisResult {
+	if len(e.IssueTypes) == 0 {
+		e.Reason = "No similar logs have been found"
+	} else {
+		e.Reason = fmt.Sprintf("Confidence %.2f of %s is lower than %.2f", e.IssueTypes[0].Score, e.IssueTypes[0].IssueType, cfg.MinConfidence)
+	}
+	return &AnalysisResult{TestItem: testItem, Explanation: e}
+}
+
+//matchedTerms collects terms of all the fields which contributed to the hit score sorted by field and term
+func matchedTerms(e *HitExplanation) []MatchedTerm {
+	if nil == e {
+		return nil
+	}
+	set := map[MatchedTerm]bool{}
+	var collect func(e HitExplanation)
+	collect = func(e HitExplanation) {
+		if m := weightRe.FindStringSubmatch(e.Description); nil != m {
+			set[MatchedTerm{Field: m[1], Term: m[2]}] = true
+		}
+		for _, d := range e.Details {
+			collect(d)
+		}
+	}
+	collect(*e)
+
+	terms := make([]MatchedTerm, 0, len(set))
+	for t := range set {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Field != terms[j].Field {
+			return terms[i].Field < terms[j].Field
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	return terms
+}
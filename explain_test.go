@@ -0,0 +1,211 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const explainedSearchRs = `{
+  "hits": {
+    "total": {"value": 1, "relation": "eq"},
+    "max_score": 12.5,
+    "hits": [{
+      "_id": "7",
+      "_score": 12.5,
+      "_source": {"issue_type": "PB001", "launch_name": "Launch 1", "test_item": 3},
+      "_explanation": {
+        "value": 12.5,
+        "description": "sum of:",
+        "details": [
+          {"value": 8, "description": "weight(message:refused in 0) [PerFieldSimilarity], result of:"},
+          {"value": 4, "description": "weight(message:connection in 0) [PerFieldSimilarity], result of:",
+           "details": [{"value": 4, "description": "weight(message:refused in 0) [PerFieldSimilarity]"}]},
+          {"value": 2, "description": "weight(stack_trace:socketexception in 0) [PerFieldSimilarity]"},
+          {"value": 0.5, "description": "weight(unique_id:unique1 in 0) [PerFieldSimilarity]"}
+        ]
+      }
+    }]
+  }
+}`
+
+func Test_matchedTerms(t *testing.T) {
+	rs := &SearchResult{}
+	assert.NoError(t, json.Unmarshal([]byte(explainedSearchRs), rs))
+
+	assert.Equal(t, []MatchedTerm{
+		{Field: "message", Term: "connection"},
+		{Field: "message", Term: "refused"},
+		{Field: "stack_trace", Term: "socketexception"},
+		{Field: "unique_id", Term: "unique1"},
+	}, matchedTerms(rs.Hits.Hits[0].Explanation))
+	assert.Nil(t, matchedTerms(nil))
+}
+
+func TestAnalyzeLogsExplain(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rq, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(rq), `"explain":true`)
+
+		responses := make([]string, strings.Count(string(rq), "\n")/2)
+		for i := range responses {
+			responses[i] = explainedSearchRs
+		}
+		if _, wErr := w.Write([]byte(msearchRs(responses...))); wErr != nil {
+			log.Error(wErr)
+		}
+	}))
+	defer ts.Close()
+	c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+
+	launches := []Launch{}
+	assert.NoError(t, json.Unmarshal([]byte(getFixture(LaunchWTestItemsWLogs)), &launches))
+	launches[0].Explain = true
+
+	results, err := c.AnalyzeLogs(launches)
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 1) || !assert.NotNil(t, results[0].Explanation) {
+		return
+	}
+
+	e := results[0].Explanation
+	assert.Len(t, e.Queries, 2)
+	assert.Equal(t, []IssueTypeScore{{IssueType: "PB001", Score: 1, RelevantItem: 3, RelevantLog: 7}}, e.IssueTypes)
+	if assert.Len(t, e.Hits, 2) {
+		assert.Equal(t, ExplainedHit{
+			Query:      1,
+			LogID:      7,
+			TestItem:   3,
+			LaunchName: "Launch 1",
+			IssueType:  "PB001",
+			Score:      12.5,
+			MatchedTerms: []MatchedTerm{
+				{Field: "message", Term: "connection"},
+				{Field: "message", Term: "refused"},
+				{Field: "stack_trace", Term: "socketexception"},
+				{Field: "unique_id", Term: "unique1"},
+			},
+		}, e.Hits[1])
+	}
+}
+
+func TestAnalyzeLogsExplainsUnpredicted(t *testing.T) {
+	tests := []struct {
+		name     string
+		rs       string
+		explain  bool
+		expected []AnalysisResult
+	}{
+		{
+			name:    "confidence is too low",
+			rs:      explainedSearchRs,
+			explain: true,
+			expected: []AnalysisResult{{TestItem: 2, Explanation: &Explanation{
+				Reason:     "Confidence 1.00 of PB001 is lower than 1.50",
+				IssueTypes: []IssueTypeScore{{IssueType: "PB001", Score: 1, RelevantItem: 3, RelevantLog: 7}},
+			}}},
+		},
+		{
+			name:     "no similar logs",
+			rs:       getFixture(NoHitsSearchRs),
+			explain:  true,
+			expected: []AnalysisResult{{TestItem: 2, Explanation: &Explanation{Reason: "No similar logs have been found", IssueTypes: []IssueTypeScore{}}}},
+		},
+		{
+			name:     "explanation is not requested",
+			rs:       explainedSearchRs,
+			expected: []AnalysisResult{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rq, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				responses := make([]string, strings.Count(string(rq), "\n")/2)
+				for i := range responses {
+					responses[i] = tt.rs
+				}
+				if _, wErr := w.Write([]byte(msearchRs(responses...))); wErr != nil {
+					log.Error(wErr)
+				}
+			}))
+			defer ts.Close()
+			searchCfg := defaultSearchConfig()
+			searchCfg.MinConfidence = 1.5
+			c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), searchCfg)
+
+			launches := []Launch{}
+			assert.NoError(t, json.Unmarshal([]byte(getFixture(LaunchWTestItemsWLogs)), &launches))
+			launches[0].Explain = tt.explain
+
+			results, err := c.AnalyzeLogs(launches)
+			assert.NoError(t, err)
+			//queries and hits are covered by TestAnalyzeLogsExplain
+			for _, rs := range results {
+				rs.Explanation.Queries = nil
+				rs.Explanation.Hits = nil
+			}
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestExplainLogs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rq, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		responses := make([]string, strings.Count(string(rq), "\n")/2)
+		for i := range responses {
+			responses[i] = explainedSearchRs
+		}
+		if _, wErr := w.Write([]byte(msearchRs(responses...))); wErr != nil {
+			log.Error(wErr)
+		}
+	}))
+	defer ts.Close()
+	searchCfg := defaultSearchConfig()
+	searchCfg.MinConfidence = 1.5
+	h := NewRequestHandler(newTestClient(t, []string{ts.URL}, defaultClientConfig(), searchCfg))
+
+	launches := []Launch{}
+	assert.NoError(t, json.Unmarshal([]byte(getFixture(LaunchWTestItemsWLogs)), &launches))
+	launches[0].Explain = true
+
+	//caller of analyze request takes every result for prediction
+	rs, err := h.AnalyzeLogs(context.Background(), launches)
+	assert.NoError(t, err)
+	assert.Equal(t, []AnalysisResult{}, rs)
+
+	rs, err = h.ExplainLogs(context.Background(), launches)
+	assert.NoError(t, err)
+	if explained, ok := rs.(*ExplainedAnalysis); assert.True(t, ok) {
+		assert.Empty(t, explained.Predicted)
+		if assert.Len(t, explained.Unpredicted, 1) {
+			assert.Equal(t, int64(2), explained.Unpredicted[0].TestItem)
+			assert.Equal(t, "Confidence 1.00 of PB001 is lower than 1.50", explained.Unpredicted[0].Explanation.Reason)
+		}
+	}
+}
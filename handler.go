@@ -15,40 +15,165 @@
  */
 package main
 
-type requestHandler func([]Launch) (interface{}, error)
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
 
-type searchRequestHandler func(SearchLogs) (interface{}, error)
+var validate = validator.New()
 
-//RequestHandler handles ES-related requests
+type requestHandler func(context.Context, []Launch) (interface{}, error)
+
+type searchRequestHandler func(context.Context, SearchLogs) (interface{}, error)
+
+//Backend is a storage of indexed logs able to analyze and search them
+//Context variants of operations are cancelled once context is done
+type Backend interface {
+	DeleteIndex(name int64) (*Response, error)
+
+	IndexLogs(launches []Launch) (*BulkResponse, error)
+	DeleteLogs(ci *CleanIndex) (*Response, error)
+	AnalyzeLogs(launches []Launch) ([]AnalysisResult, error)
+	SearchLogs(request SearchLogs) ([]int64, error)
+
+	DeleteIndexContext(ctx context.Context, name int64) (*Response, error)
+	IndexLogsContext(ctx context.Context, launches []Launch) (*BulkResponse, error)
+	DeleteLogsContext(ctx context.Context, ci *CleanIndex) (*Response, error)
+	AnalyzeLogsContext(ctx context.Context, launches []Launch) ([]AnalysisResult, error)
+	SearchLogsContext(ctx context.Context, request SearchLogs) ([]int64, error)
+
+	Healthy() bool
+}
+
+//RequestHandler handles storage-related requests
 type RequestHandler struct {
-	c ESClient
+	c Backend
 }
 
 //NewRequestHandler creates new instance of handler
-func NewRequestHandler(c ESClient) *RequestHandler {
+func NewRequestHandler(c Backend) *RequestHandler {
 	return &RequestHandler{c: c}
 }
 
 //IndexLaunches indexes launches
-func (h *RequestHandler) IndexLaunches(launches []Launch) (interface{}, error) {
-	return h.c.IndexLogs(launches)
+func (h *RequestHandler) IndexLaunches(ctx context.Context, launches []Launch) (interface{}, error) {
+	return h.c.IndexLogsContext(ctx, launches)
 }
 
-//AnalyzeLogs analyzes the logs
-func (h *RequestHandler) AnalyzeLogs(launches []Launch) (interface{}, error) {
-	return h.c.AnalyzeLogs(launches)
+//AnalyzeLogs analyzes the logs. Caller takes every result for prediction so test items issue type
+//has not been predicted for are not replied. Their explanations are replied by ExplainLogs only
+func (h *RequestHandler) AnalyzeLogs(ctx context.Context, launches []Launch) (interface{}, error) {
+	rs, err := h.c.AnalyzeLogsContext(ctx, launches)
+	if nil != err {
+		return nil, err
+	}
+	predicted, _ := splitPredictions(rs)
+	observePredictions(launches, predicted)
+	return predicted, nil
 }
 
-func (h *RequestHandler) SearchLogs(request SearchLogs) (interface{}, error) {
-	return h.c.SearchLogs(request)
+//ExplainedAnalysis is a result of analysis explaining both predictions and test items issue type has not been predicted for
+type ExplainedAnalysis struct {
+	Predicted   []AnalysisResult `json:"predicted"`
+	Unpredicted []AnalysisResult `json:"unpredicted"`
+}
+
+//ExplainLogs analyzes the logs explaining predictions made for all the launches
+func (h *RequestHandler) ExplainLogs(ctx context.Context, launches []Launch) (interface{}, error) {
+	for i := range launches {
+		launches[i].Explain = true
+	}
+	rs, err := h.c.AnalyzeLogsContext(ctx, launches)
+	if nil != err {
+		return nil, err
+	}
+	predicted, unpredicted := splitPredictions(rs)
+	observePredictions(launches, predicted)
+	return &ExplainedAnalysis{Predicted: predicted, Unpredicted: unpredicted}, nil
+}
+
+//splitPredictions separates results of test items issue type has been predicted for from the explained rest of them
+func splitPredictions(results []AnalysisResult) (predicted, unpredicted []AnalysisResult) {
+	predicted, unpredicted = []AnalysisResult{}, []AnalysisResult{}
+	for _, rs := range results {
+		if "" == rs.IssueType {
+			unpredicted = append(unpredicted, rs)
+		} else {
+			predicted = append(predicted, rs)
+		}
+	}
+	return predicted, unpredicted
+}
+
+func (h *RequestHandler) SearchLogs(ctx context.Context, request SearchLogs) (interface{}, error) {
+	return h.c.SearchLogsContext(ctx, request)
 }
 
 //DeleteIndex deletes index
-func (h *RequestHandler) DeleteIndex(id int64) (*Response, error) {
-	return h.c.DeleteIndex(id)
+func (h *RequestHandler) DeleteIndex(ctx context.Context, id int64) (*Response, error) {
+	return h.c.DeleteIndexContext(ctx, id)
 }
 
 //CleanIndex cleans index
-func (h *RequestHandler) CleanIndex(ci *CleanIndex) (*Response, error) {
-	return h.c.DeleteLogs(ci)
+func (h *RequestHandler) CleanIndex(ctx context.Context, ci *CleanIndex) (*Response, error) {
+	return h.c.DeleteLogsContext(ctx, ci)
+}
+
+//parseLaunches unmarshals and validates launches of index and analyze requests
+func parseLaunches(body []byte) ([]Launch, error) {
+	var launches []Launch
+	if err := json.Unmarshal(body, &launches); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for i, l := range launches {
+		if err := validate.Struct(l); nil != err {
+			return nil, errors.WithStack(&launchValidationError{index: i, err: err})
+		}
+	}
+	return launches, nil
+}
+
+//launchValidationError is returned when one of the request launches is not valid
+type launchValidationError struct {
+	index int
+	err   error
+}
+
+func (e *launchValidationError) Error() string {
+	return fmt.Sprintf("Validation failed on Launch[%d]: %v", e.index, e.err)
+}
+
+//parseSearchLogs unmarshals search logs request
+func parseSearchLogs(body []byte) (SearchLogs, error) {
+	var request SearchLogs
+	if err := json.Unmarshal(body, &request); err != nil {
+		return request, errors.WithStack(err)
+	}
+	return request, nil
+}
+
+//parseCleanIndex unmarshals and validates clean index request
+func parseCleanIndex(body []byte) (*CleanIndex, error) {
+	var ci CleanIndex
+	if err := json.Unmarshal(body, &ci); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := validate.Struct(ci); nil != err {
+		return nil, errors.Wrapf(err, "Validation failed on CleanIndex")
+	}
+	return &ci, nil
+}
+
+//parseProjectID unmarshals ID of the project which index should be deleted
+func parseProjectID(body []byte) (int64, error) {
+	var id int64
+	if err := json.Unmarshal(body, &id); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return id, nil
 }
@@ -0,0 +1,168 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/reportportal/commons-go/server"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//Health statuses of the analyzer and its components
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+//ComponentHealth is a health of single component the analyzer depends on
+type ComponentHealth struct {
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+//HealthReport is a health of the analyzer. Analyzer is up only if all the components are up
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+//clusterHealthReporter is a backend able to report details of its storage health
+type clusterHealthReporter interface {
+	ClusterHealthContext(ctx context.Context) (*ClusterHealth, error)
+}
+
+//amqpHealthReporter is a source of AMQP connection and consumers state
+type amqpHealthReporter interface {
+	CheckConnection() (connErr, chErr error)
+	Consumers() map[string]ConsumerStatus
+}
+
+//HealthChecker checks components the analyzer depends on
+type HealthChecker struct {
+	backend Backend
+	amqp    amqpHealthReporter
+	queues  []string
+}
+
+//NewHealthChecker creates checker of backend, AMQP connection and consumers of provided queues
+func NewHealthChecker(backend Backend, amqp amqpHealthReporter, queues []string) *HealthChecker {
+	return &HealthChecker{backend: backend, amqp: amqp, queues: queues}
+}
+
+//Live checks the analyzer process does not need to be restarted so it's up as long as it responds.
+//Consumers are not registered until AMQP connection is established and lost connections are restored
+//by the analyzer itself so state of the components is reported by readiness only
+func (hc *HealthChecker) Live(ctx context.Context) *HealthReport {
+	return newHealthReport(map[string]ComponentHealth{})
+}
+
+//Ready checks the analyzer is able to process requests
+func (hc *HealthChecker) Ready(ctx context.Context) *HealthReport {
+	return newHealthReport(map[string]ComponentHealth{
+		"backend":   hc.checkBackend(ctx),
+		"amqp":      hc.checkAmqp(),
+		"consumers": hc.checkConsumers(func(s ConsumerStatus) bool { return ConsumerConsuming == s.State }),
+	})
+}
+
+func (hc *HealthChecker) checkBackend(ctx context.Context) ComponentHealth {
+	reporter, ok := hc.backend.(clusterHealthReporter)
+	if !ok {
+		return componentHealth(hc.backend.Healthy(), nil, nil)
+	}
+	rs, err := reporter.ClusterHealthContext(ctx)
+	if nil != err {
+		return componentHealth(false, err, nil)
+	}
+	if !rs.Operational() {
+		return componentHealth(false, errors.Errorf("ES cluster status is %s", rs.Status), rs)
+	}
+	return componentHealth(true, nil, rs)
+}
+
+func (hc *HealthChecker) checkAmqp() ComponentHealth {
+	connErr, chErr := hc.amqp.CheckConnection()
+	details := map[string]string{"connection": StatusUp, "channel": StatusUp}
+	if nil != connErr {
+		details["connection"] = StatusDown
+	}
+	if nil != chErr {
+		details["channel"] = StatusDown
+	}
+	if nil != connErr {
+		return componentHealth(false, connErr, details)
+	}
+	return componentHealth(nil == chErr, errors.Wrap(chErr, "Cannot open AMQP channel"), details)
+}
+
+//checkConsumers checks consumer of each queue is registered and healthy
+func (hc *HealthChecker) checkConsumers(healthy func(s ConsumerStatus) bool) ComponentHealth {
+	consumers := hc.amqp.Consumers()
+	var failed []string
+	for _, q := range hc.queues {
+		if s, ok := consumers[q]; !ok || !healthy(s) {
+			failed = append(failed, q)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return componentHealth(false, errors.Errorf("Consumers of queues %s are not healthy", strings.Join(failed, ", ")), consumers)
+	}
+	return componentHealth(true, nil, consumers)
+}
+
+func componentHealth(up bool, err error, details interface{}) ComponentHealth {
+	c := ComponentHealth{Status: StatusUp, Details: details}
+	if !up {
+		c.Status = StatusDown
+	}
+	if nil != err {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+func newHealthReport(components map[string]ComponentHealth) *HealthReport {
+	rs := &HealthReport{Status: StatusUp, Components: components}
+	for _, c := range components {
+		if StatusUp != c.Status {
+			rs.Status = StatusDown
+		}
+	}
+	return rs
+}
+
+//initHealthHandlers exposes liveness and readiness probes. Both respond with 503 status if analyzer is down.
+//Default health endpoint is left without checks so probes pointed to it do not restart analyzer while ES or AMQP is down
+func initHealthHandlers(srv *server.RpServer, hc *HealthChecker) {
+	srv.AddHandler(http.MethodGet, "/health/live", handleHealthRequest(hc.Live))
+	srv.AddHandler(http.MethodGet, "/health/ready", handleHealthRequest(hc.Ready))
+}
+
+func handleHealthRequest(check func(ctx context.Context) *HealthReport) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		rs := check(rq.Context())
+		status := http.StatusOK
+		if StatusUp != rs.Status {
+			status = http.StatusServiceUnavailable
+		}
+		return server.WriteJSON(status, rs, w)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/reportportal/commons-go/commons"
+	"github.com/reportportal/commons-go/conf"
+	"github.com/reportportal/commons-go/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stubAmqp struct {
+	connErr   error
+	chErr     error
+	consumers map[string]ConsumerStatus
+}
+
+func (a *stubAmqp) CheckConnection() (error, error) {
+	return a.connErr, a.chErr
+}
+
+func (a *stubAmqp) Consumers() map[string]ConsumerStatus {
+	return a.consumers
+}
+
+func consuming(queues ...string) map[string]ConsumerStatus {
+	consumers := map[string]ConsumerStatus{}
+	for _, q := range queues {
+		consumers[q] = ConsumerStatus{State: ConsumerConsuming, Workers: 1}
+	}
+	return consumers
+}
+
+func TestHealthChecks(t *testing.T) {
+	tests := []struct {
+		name           string
+		calls          []ServerCall
+		amqp           *stubAmqp
+		liveStatus     int
+		readyStatus    int
+		downComponents []string
+	}{
+		{
+			name: "healthy",
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cluster/health",
+					rs:     `{"cluster_name":"analyzer","status":"green","number_of_nodes":3}`,
+					status: http.StatusOK,
+				},
+			},
+			amqp:        &stubAmqp{consumers: consuming(queues...)},
+			liveStatus:  http.StatusOK,
+			readyStatus: http.StatusOK,
+		},
+		{
+			name: "es cluster is red",
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cluster/health",
+					rs:     `{"cluster_name":"analyzer","status":"red","number_of_nodes":1}`,
+					status: http.StatusOK,
+				},
+			},
+			amqp:           &stubAmqp{consumers: consuming(queues...)},
+			liveStatus:     http.StatusOK,
+			readyStatus:    http.StatusServiceUnavailable,
+			downComponents: []string{"backend"},
+		},
+		{
+			name: "amqp connection is lost",
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cluster/health",
+					rs:     `{"cluster_name":"analyzer","status":"yellow","number_of_nodes":1}`,
+					status: http.StatusOK,
+				},
+			},
+			amqp: &stubAmqp{
+				connErr: errors.New("AMQP connection is closed"),
+				chErr:   errors.New("AMQP connection is closed"),
+				consumers: map[string]ConsumerStatus{
+					indexQueue:   {State: ConsumerWaiting},
+					analyzeQueue: {State: ConsumerWaiting},
+					deleteQueue:  {State: ConsumerWaiting},
+					clearQueue:   {State: ConsumerWaiting},
+					searchQueue:  {State: ConsumerWaiting},
+				},
+			},
+			liveStatus:     http.StatusOK,
+			readyStatus:    http.StatusServiceUnavailable,
+			downComponents: []string{"amqp", "consumers"},
+		},
+		{
+			name: "consumer has stopped",
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cluster/health",
+					rs:     `{"cluster_name":"analyzer","status":"green","number_of_nodes":1}`,
+					status: http.StatusOK,
+				},
+			},
+			amqp: &stubAmqp{consumers: func() map[string]ConsumerStatus {
+				consumers := consuming(indexQueue, analyzeQueue, deleteQueue, clearQueue)
+				consumers[searchQueue] = ConsumerStatus{State: ConsumerStopped}
+				return consumers
+			}()},
+			liveStatus:     http.StatusOK,
+			readyStatus:    http.StatusServiceUnavailable,
+			downComponents: []string{"consumers"},
+		},
+		{
+			name: "consumers are not registered yet",
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_cluster/health",
+					rs:     `{"cluster_name":"analyzer","status":"green","number_of_nodes":1}`,
+					status: http.StatusOK,
+				},
+			},
+			amqp:           &stubAmqp{connErr: errors.New("AMQP connection is not established"), consumers: map[string]ConsumerStatus{}},
+			liveStatus:     http.StatusOK,
+			readyStatus:    http.StatusServiceUnavailable,
+			downComponents: []string{"amqp", "consumers"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			i := 0
+			ts := startServer(t, tt.calls, &i)
+			defer ts.Close()
+
+			srv := server.New(conf.EmptyConfig(), &commons.BuildInfo{})
+			backend := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+			initHealthHandlers(srv, NewHealthChecker(backend, tt.amqp, queues))
+
+			var router http.Handler
+			srv.WithRouter(func(mux *chi.Mux) {
+				router = mux
+			})
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+			assert.Equal(t, tt.liveStatus, rr.Code)
+
+			rr = httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+			assert.Equal(t, tt.readyStatus, rr.Code)
+			assert.Equal(t, len(tt.calls), i)
+
+			rs := &HealthReport{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), rs))
+			assert.Len(t, rs.Components, 3)
+			for name, c := range rs.Components {
+				if contains(tt.downComponents, name) {
+					assert.Equal(t, StatusDown, c.Status, name)
+					assert.NotEmpty(t, c.Error, name)
+				} else {
+					assert.Equal(t, StatusUp, c.Status, name)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthChecksOfMemoryBackend(t *testing.T) {
+	hc := NewHealthChecker(NewMemoryBackend(defaultSearchConfig()), &stubAmqp{consumers: consuming(queues...)}, queues)
+	rs := hc.Ready(context.Background())
+	assert.Equal(t, StatusUp, rs.Status)
+	assert.Nil(t, rs.Components["backend"].Details)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/reportportal/commons-go/server"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+//initHTTPHandlers exposes the same operations as AMQP queues do over HTTP along with metrics of the analyzer.
+//Operations changing indices are exposed only if write is enabled since HTTP API has no authentication
+func initHTTPHandlers(srv *server.RpServer, h *RequestHandler, writeEnabled bool) {
+	srv.AddHandler(http.MethodPost, "/analyze", instrumentHTTP("analyze", handleHTTPRequest(h.AnalyzeLogs)))
+	//explanations are not replied by analyze request since caller takes every result for prediction
+	srv.AddHandler(http.MethodPost, "/analyze/explain", instrumentHTTP("explain", handleHTTPRequest(h.ExplainLogs)))
+	srv.AddHandler(http.MethodPost, "/search", instrumentHTTP("search", handleHTTPSearchRequest(h.SearchLogs)))
+	if writeEnabled {
+		srv.AddHandler(http.MethodPost, "/index", instrumentHTTP("index", handleHTTPRequest(h.IndexLaunches)))
+		srv.AddHandler(http.MethodDelete, "/index/{project}", instrumentHTTP("delete", handleHTTPDeleteRequest(h)))
+		srv.AddHandler(http.MethodPost, "/clean", instrumentHTTP("clean", handleHTTPCleanRequest(h)))
+	}
+	srv.WithRouter(func(router *chi.Mux) {
+		router.Handle("/metrics", promhttp.Handler())
+	})
+}
+
+func handleHTTPRequest(handler requestHandler) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		body, err := readBody(rq)
+		if err != nil {
+			return err
+		}
+
+		launches, err := parseLaunches(body)
+		if err != nil {
+			return server.ToStatusError(http.StatusBadRequest, err)
+		}
+
+		rs, err := handler(rq.Context(), launches)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return server.WriteJSON(http.StatusOK, rs, w)
+	}
+}
+
+func handleHTTPSearchRequest(handler searchRequestHandler) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		body, err := readBody(rq)
+		if err != nil {
+			return err
+		}
+
+		request, err := parseSearchLogs(body)
+		if err != nil {
+			return server.ToStatusError(http.StatusBadRequest, err)
+		}
+
+		rs, err := handler(rq.Context(), request)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return server.WriteJSON(http.StatusOK, rs, w)
+	}
+}
+
+func handleHTTPDeleteRequest(h *RequestHandler) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(rq, "project"), 10, 64)
+		if err != nil {
+			return server.ToStatusError(http.StatusBadRequest, errors.Wrap(err, "Incorrect project ID"))
+		}
+
+		rs, err := h.DeleteIndex(rq.Context(), id)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return server.WriteJSON(http.StatusOK, rs, w)
+	}
+}
+
+func handleHTTPCleanRequest(h *RequestHandler) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		body, err := readBody(rq)
+		if err != nil {
+			return err
+		}
+
+		ci, err := parseCleanIndex(body)
+		if err != nil {
+			return server.ToStatusError(http.StatusBadRequest, err)
+		}
+
+		rs, err := h.CleanIndex(rq.Context(), ci)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return server.WriteJSON(http.StatusOK, rs, w)
+	}
+}
+
+func readBody(rq *http.Request) ([]byte, error) {
+	defer func() {
+		if err := rq.Body.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(rq.Body)
+	if err != nil {
+		return nil, server.ToStatusError(http.StatusBadRequest, errors.Wrap(err, "Cannot read request body"))
+	}
+	return body, nil
+}
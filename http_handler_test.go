@@ -0,0 +1,143 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/reportportal/commons-go/commons"
+	"github.com/reportportal/commons-go/conf"
+	"github.com/reportportal/commons-go/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPHandlers(t *testing.T) {
+	tests := []struct {
+		calls          []ServerCall
+		method         string
+		uri            string
+		rq             string
+		readOnly       bool
+		expectedStatus int
+	}{
+		{
+			method:         http.MethodPost,
+			uri:            "/analyze",
+			rq:             "not a json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			method:         http.MethodPost,
+			uri:            "/analyze/explain",
+			rq:             "not a json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			method:         http.MethodPost,
+			uri:            "/index",
+			rq:             `[{"launchName":"no-id"}]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			method:         http.MethodPost,
+			uri:            "/clean",
+			rq:             `{"ids":[1,2]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			method:         http.MethodDelete,
+			uri:            "/index/abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			method:         http.MethodPost,
+			uri:            "/index",
+			rq:             getFixture(LaunchWTestItemsWLogs),
+			readOnly:       true,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			method:         http.MethodDelete,
+			uri:            "/index/1",
+			readOnly:       true,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			method:         http.MethodPost,
+			uri:            "/clean",
+			rq:             `{"project":1,"ids":[1,2]}`,
+			readOnly:       true,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/_alias/1",
+					rs:     `{"1_v2":{"aliases":{"1":{}}}}`,
+					status: http.StatusOK,
+				},
+				{
+					method: "DELETE",
+					uri:    "/1_v2",
+					rs:     getFixture(IndexDeletedRs),
+					status: http.StatusOK,
+				},
+			},
+			method:         http.MethodDelete,
+			uri:            "/index/1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			calls: []ServerCall{
+				{
+					method: "GET",
+					uri:    "/2/_msearch",
+					rq:     msearchRq(2, getFixture(SearchRq)),
+					rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(OneHitSearchRs)),
+					status: http.StatusOK,
+				},
+			},
+			method:         http.MethodPost,
+			uri:            "/analyze",
+			rq:             getFixture(LaunchWTestItemsWLogs),
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		i := 0
+		ts := startServer(t, test.calls, &i)
+		defer ts.Close()
+
+		srv := server.New(conf.EmptyConfig(), &commons.BuildInfo{})
+		initHTTPHandlers(srv, NewRequestHandler(newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())), !test.readOnly)
+
+		var router http.Handler
+		srv.WithRouter(func(mux *chi.Mux) {
+			router = mux
+		})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(test.method, test.uri, strings.NewReader(test.rq)))
+
+		assert.Equal(t, len(test.calls), i)
+		assert.Equal(t, test.expectedStatus, rr.Code)
+	}
+}
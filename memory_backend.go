@@ -0,0 +1,536 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//Supported backend types
+const (
+	BackendElasticsearch = "elasticsearch"
+	BackendMemory        = "memory"
+)
+
+//englishStopWords is the same list as '_english_' stop words of ES standard analyzer
+var englishStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true,
+	"for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+//memoryDoc is an indexed log
+type memoryDoc struct {
+	id             int64
+	launchID       int64
+	launchName     string
+	testItem       int64
+	uniqueID       string
+	isAutoAnalyzed bool
+	issueType      string
+	logLevel       int
+	message        string
+	exceptionType  string
+	logTime        *time.Time
+	terms          map[string]int
+}
+
+//memoryIndex contains logs of a single project
+type memoryIndex struct {
+	docs map[int64]*memoryDoc
+	//df is a number of docs containing term
+	df map[string]int
+}
+
+type memoryBackend struct {
+	mu         sync.RWMutex
+	indices    map[int64]*memoryIndex
+	normalizer *Normalizer
+	strategy   ScoringStrategy
+	searchCfg  *SearchConfig
+}
+
+//NewMemoryBackend creates backend keeping indexed logs in memory.
+//Similarity of logs is calculated as cosine similarity of TF-IDF vectors of log messages
+func NewMemoryBackend(searchCfg *SearchConfig) Backend {
+	return &memoryBackend{
+		indices:    map[int64]*memoryIndex{},
+		normalizer: newNormalizer(searchCfg),
+		strategy:   newScoringStrategy(searchCfg),
+		searchCfg:  searchCfg,
+	}
+}
+
+//Healthy is always TRUE since there is no remote storage
+func (b *memoryBackend) Healthy() bool {
+	return true
+}
+
+//Operations of memory backend never block so context is only checked before the operation starts
+
+func (b *memoryBackend) DeleteIndexContext(ctx context.Context, name int64) (*Response, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	return b.DeleteIndex(name)
+}
+
+func (b *memoryBackend) DeleteLogsContext(ctx context.Context, ci *CleanIndex) (*Response, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	return b.DeleteLogs(ci)
+}
+
+func (b *memoryBackend) IndexLogsContext(ctx context.Context, launches []Launch) (*BulkResponse, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	return b.IndexLogs(launches)
+}
+
+func (b *memoryBackend) AnalyzeLogsContext(ctx context.Context, launches []Launch) ([]AnalysisResult, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	return b.AnalyzeLogs(launches)
+}
+
+func (b *memoryBackend) SearchLogsContext(ctx context.Context, request SearchLogs) ([]int64, error) {
+	if err := ctx.Err(); nil != err {
+		return nil, err
+	}
+	return b.SearchLogs(request)
+}
+
+func (b *memoryBackend) DeleteIndex(name int64) (*Response, error) {
+	log.Debugf("Deleting index %d", name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.indices[name]; !ok {
+		return &Response{Status: http.StatusNotFound}, nil
+	}
+	delete(b.indices, name)
+	return &Response{Acknowledged: true}, nil
+}
+
+func (b *memoryBackend) DeleteLogs(ci *CleanIndex) (*Response, error) {
+	log.Debugf("Deleting logs %v", ci.IDs)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if idx, ok := b.indices[ci.Project]; ok {
+		for _, id := range ci.IDs {
+			idx.remove(id)
+		}
+	}
+	return &Response{}, nil
+}
+
+func (b *memoryBackend) IndexLogs(launches []Launch) (*BulkResponse, error) {
+	log.Debugf("Indexing logs for %d launches", len(launches))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rs := &BulkResponse{Summary: &IndexSummary{}}
+	for _, lc := range launches {
+		idx, ok := b.indices[lc.Project]
+		if !ok {
+			idx = &memoryIndex{docs: map[int64]*memoryDoc{}, df: map[string]int{}}
+			b.indices[lc.Project] = idx
+		}
+		for _, ti := range lc.TestItems {
+			for _, l := range ti.Logs {
+				if l.LogLevel < ErrorLoggingLevel {
+					rs.Summary.SkippedByLevel++
+					continue
+				}
+
+				pl := b.normalizer.Parse(l.Message, lc.Conf.LogLines, b.searchCfg.StackTraceFrames)
+				created := idx.add(&memoryDoc{
+					id:             l.LogID,
+					launchID:       lc.LaunchID,
+					launchName:     lc.LaunchName,
+					testItem:       ti.TestItemID,
+					uniqueID:       ti.UniqueID,
+					isAutoAnalyzed: ti.IsAutoAnalyzed,
+					issueType:      ti.IssueType,
+					logLevel:       l.LogLevel,
+					message:        pl.Message,
+					exceptionType:  pl.ExceptionType,
+					logTime:        l.LogTime,
+					terms:          termFrequencies(pl.Message),
+				})
+
+				rs.Items = append(rs.Items, bulkItem(lc.Project, l.LogID, created))
+				rs.Summary.Indexed++
+			}
+		}
+	}
+	return rs, nil
+}
+
+func (b *memoryBackend) AnalyzeLogs(launches []Launch) ([]AnalysisResult, error) {
+	log.Debugf("Starting analysis for %d launches", len(launches))
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	result := []AnalysisResult{}
+	for _, lc := range launches {
+		idx, ok := b.indices[lc.Project]
+		if !ok {
+			continue
+		}
+		minDocFreq, minTermFreq, minShouldMatch := analyzeParams(b.searchCfg, lc.Conf)
+		if SearchModeCurrentLaunch == lc.Conf.Mode {
+			minDocFreq = 1
+		}
+
+		strategy := launchScoringStrategy(b.strategy, b.searchCfg, lc.Conf)
+		for _, ti := range lc.TestItems {
+			issueTypes := make(map[string]*score)
+			var queries []interface{}
+			var results []*SearchResult
+
+			for _, l := range ti.Logs {
+				if l.LogLevel < ErrorLoggingLevel {
+					continue
+				}
+
+				pl := b.normalizer.Parse(l.Message, lc.Conf.LogLines, b.searchCfg.StackTraceFrames)
+				q := idx.likeThis(pl.Message, minDocFreq, minTermFreq, b.searchCfg.MaxQueryTerms, minShouldMatch)
+
+				rs := idx.search(10, func(d *memoryDoc) (float64, bool) {
+					if strings.HasPrefix(d.issueType, "ti") || "" == d.issueType || d.logLevel < ErrorLoggingLevel {
+						return 0, false
+					}
+					boost := 0.0
+					switch lc.Conf.Mode {
+					case SearchModeAll, SearchModeNotFound:
+						if d.launchName == lc.LaunchName {
+							boost += math.Abs(b.searchCfg.BoostLaunch)
+						}
+					case SearchModeLaunchName:
+						if d.launchName != lc.LaunchName {
+							return 0, false
+						}
+					case SearchModeCurrentLaunch:
+						if d.launchID != lc.LaunchID {
+							return 0, false
+						}
+					}
+					sim, ok := q.similarity(d)
+					if !ok {
+						return 0, false
+					}
+					if d.uniqueID == ti.UniqueID {
+						boost += math.Abs(b.searchCfg.BoostUniqueID)
+					}
+					if "" != pl.ExceptionType && d.exceptionType == pl.ExceptionType {
+						boost += math.Abs(b.searchCfg.BoostExceptionType)
+					}
+					if d.isAutoAnalyzed == (b.searchCfg.BoostAA < 0) {
+						boost += math.Abs(b.searchCfg.BoostAA)
+					}
+					return sim * (1 + boost), true
+				})
+
+				strategy.Score(rs, 10, issueTypes)
+
+				if lc.Explain {
+					for i, h := range rs.Hits.Hits {
+						rs.Hits.Hits[i].Explanation = q.explain(idx.docs[relevantLog(h)], h.Score)
+					}
+					queries = append(queries, map[string]interface{}{"terms": q.weights, "minimum_should_match": q.minShouldMatch})
+					results = append(results, rs)
+				}
+			}
+
+			rs := predictIssueType(b.searchCfg, ti.TestItemID, issueTypes)
+			switch {
+			case nil != rs && lc.Explain:
+				rs.Explanation = explain(queries, results, 10, issueTypes)
+			case nil == rs && lc.Explain && len(queries) > 0:
+				rs = unpredicted(b.searchCfg, ti.TestItemID, explain(queries, results, 10, issueTypes))
+			}
+			if nil != rs {
+				result = append(result, *rs)
+			}
+		}
+	}
+	log.Debugf("Analysis has found %d matches", len(result))
+
+	return result, nil
+}
+
+func (b *memoryBackend) SearchLogs(request SearchLogs) ([]int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	keys := []int64{}
+	idx, ok := b.indices[request.ProjectID]
+	if !ok {
+		return keys, nil
+	}
+
+	launches := make(map[int64]bool, len(request.FilteredLaunchIds))
+	for _, id := range request.FilteredLaunchIds {
+		launches[id] = true
+	}
+
+	set := make(map[int64]bool)
+	for _, message := range request.LogMessages {
+		q := idx.likeThis(b.normalizer.Normalize(message, request.LogLines), 1, 1,
+			b.searchCfg.MaxQueryTerms, b.searchCfg.SearchLogsMinShouldMatch)
+
+		rs := idx.search(500, func(d *memoryDoc) (float64, bool) {
+			if d.testItem == request.ItemID || !launches[d.launchID] ||
+				!strings.HasPrefix(d.issueType, "ti") || d.logLevel < ErrorLoggingLevel {
+				return 0, false
+			}
+			sim, ok := q.similarity(d)
+			if !ok {
+				return 0, false
+			}
+			if !d.isAutoAnalyzed {
+				sim *= 2
+			}
+			return sim, true
+		})
+		for _, h := range rs.Hits.Hits {
+			id, err := strconv.ParseInt(h.ID, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			set[id] = true
+		}
+	}
+
+	for k := range set {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+//add adds or replaces the doc. Returns TRUE if doc has been created
+func (idx *memoryIndex) add(d *memoryDoc) bool {
+	created := !idx.remove(d.id)
+	idx.docs[d.id] = d
+	for t := range d.terms {
+		idx.df[t]++
+	}
+	return created
+}
+
+//remove removes the doc. Returns TRUE if doc has been found
+func (idx *memoryIndex) remove(id int64) bool {
+	d, ok := idx.docs[id]
+	if !ok {
+		return false
+	}
+	for t := range d.terms {
+		idx.df[t]--
+		if idx.df[t] <= 0 {
+			delete(idx.df, t)
+		}
+	}
+	delete(idx.docs, id)
+	return true
+}
+
+func (idx *memoryIndex) idf(term string) float64 {
+	return 1 + math.Log(float64(len(idx.docs)+1)/float64(idx.df[term]+1))
+}
+
+//search scores each doc with provided func and returns top hits in the same shape ES does
+func (idx *memoryIndex) search(size int, scoreFunc func(d *memoryDoc) (float64, bool)) *SearchResult {
+	rs := &SearchResult{}
+	for _, d := range idx.docs {
+		s, ok := scoreFunc(d)
+		if !ok || s <= 0 {
+			continue
+		}
+		h := Hit{ID: strconv.FormatInt(d.id, 10), Score: s}
+		h.Source.TestItem = d.testItem
+		h.Source.IssueType = d.issueType
+		h.Source.Message = d.message
+		h.Source.LogLevel = d.logLevel
+		h.Source.LaunchName = d.launchName
+		h.Source.LogTime = d.logTime
+		rs.Hits.Hits = append(rs.Hits.Hits, h)
+	}
+
+	sort.Slice(rs.Hits.Hits, func(i, j int) bool {
+		if rs.Hits.Hits[i].Score == rs.Hits.Hits[j].Score {
+			return rs.Hits.Hits[i].ID < rs.Hits.Hits[j].ID
+		}
+		return rs.Hits.Hits[i].Score > rs.Hits.Hits[j].Score
+	})
+	rs.Hits.Total = Total{Value: len(rs.Hits.Hits), Relation: "eq"}
+	if len(rs.Hits.Hits) > size {
+		rs.Hits.Hits = rs.Hits.Hits[:size]
+	}
+	if len(rs.Hits.Hits) > 0 {
+		rs.Hits.MaxScore = rs.Hits.Hits[0].Score
+	}
+	return rs
+}
+
+//likeThisQuery is an in-memory analogue of ES more_like_this query
+type likeThisQuery struct {
+	weights        map[string]float64
+	norm           float64
+	minShouldMatch int
+}
+
+//likeThis selects the most significant terms of the message the same way ES more_like_this query does
+func (idx *memoryIndex) likeThis(message string, minDocFreq, minTermFreq, maxQueryTerms float64, minShouldMatch string) *likeThisQuery {
+	type weightedTerm struct {
+		term   string
+		weight float64
+	}
+	var terms []weightedTerm
+	for t, tf := range termFrequencies(message) {
+		if float64(tf) < minTermFreq || float64(idx.df[t]) < minDocFreq {
+			continue
+		}
+		terms = append(terms, weightedTerm{t, float64(tf) * idx.idf(t)})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight == terms[j].weight {
+			return terms[i].term < terms[j].term
+		}
+		return terms[i].weight > terms[j].weight
+	})
+	if maxQueryTerms > 0 && len(terms) > int(maxQueryTerms) {
+		terms = terms[:int(maxQueryTerms)]
+	}
+
+	q := &likeThisQuery{weights: make(map[string]float64, len(terms))}
+	for _, t := range terms {
+		q.weights[t.term] = t.weight
+		q.norm += t.weight * t.weight
+	}
+	q.norm = math.Sqrt(q.norm)
+	q.minShouldMatch = minimumShouldMatch(len(terms), "5<"+minShouldMatch)
+	return q
+}
+
+//similarity calculates cosine similarity between query and the doc
+//returns FALSE if doc doesn't contain enough query terms
+func (q *likeThisQuery) similarity(d *memoryDoc) (float64, bool) {
+	if 0 == len(q.weights) {
+		return 0, false
+	}
+	matched := 0
+	dot, docNorm := 0.0, 0.0
+	for t, tf := range d.terms {
+		w := float64(tf)
+		docNorm += w * w
+		if qw, ok := q.weights[t]; ok {
+			matched++
+			dot += qw * w
+		}
+	}
+	if matched < q.minShouldMatch || 0 == docNorm {
+		return 0, false
+	}
+	return dot / (q.norm * math.Sqrt(docNorm)), true
+}
+
+//explain describes doc score the same way ES does so matched terms are obtained the same way for both backends
+func (q *likeThisQuery) explain(d *memoryDoc, score float64) *HitExplanation {
+	e := &HitExplanation{Value: score, Description: "sum of:"}
+	if nil == d {
+		return e
+	}
+	terms := make([]string, 0, len(d.terms))
+	for t := range d.terms {
+		if _, ok := q.weights[t]; ok {
+			terms = append(terms, t)
+		}
+	}
+	sort.Strings(terms)
+	for _, t := range terms {
+		e.Details = append(e.Details, HitExplanation{
+			Value:       q.weights[t] * float64(d.terms[t]),
+			Description: fmt.Sprintf("weight(message:%s in %d)", t, d.id),
+		})
+	}
+	return e
+}
+
+//minimumShouldMatch calculates number of required terms from ES-like spec such as "80%", "3" or "5<80%"
+func minimumShouldMatch(n int, spec string) int {
+	if i := strings.Index(spec, "<"); i > 0 {
+		threshold, err := strconv.Atoi(spec[:i])
+		if err == nil && n <= threshold {
+			return n
+		}
+		spec = spec[i+1:]
+	}
+	if strings.HasSuffix(spec, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil {
+			return n
+		}
+		return int(math.Floor(float64(n) * pct / 100))
+	}
+	required, err := strconv.Atoi(spec)
+	if err != nil || required > n {
+		return n
+	}
+	return required
+}
+
+//termFrequencies splits text into lowercase terms omitting english stop words
+func termFrequencies(text string) map[string]int {
+	tf := map[string]int{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !englishStopWords[t] {
+			tf[t]++
+		}
+	}
+	return tf
+}
+
+func bulkItem(project, id int64, created bool) BulkItem {
+	var item BulkItem
+	item.Index.Index = strconv.FormatInt(project, 10)
+	item.Index.ID = strconv.FormatInt(id, 10)
+	item.Index.Created = created
+	if created {
+		item.Index.Result = "created"
+		item.Index.Status = http.StatusCreated
+	} else {
+		item.Index.Result = "updated"
+		item.Index.Status = http.StatusOK
+	}
+	return item
+}
@@ -0,0 +1,187 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+const memoryIndexRq = `[{
+  "launchId": 1,
+  "project": 5,
+  "launchName": "regression",
+  "testItems": [
+    {"testItemId": 10, "uniqueId": "u10", "issueType": "AB001", "logs": [
+      {"logId": 100, "logLevel": 40000, "message": "NullPointerException at service.login line 42"}]},
+    {"testItemId": 11, "uniqueId": "u11", "issueType": "PB001", "logs": [
+      {"logId": 101, "logLevel": 40000, "message": "Connection refused to database host 10.0.0.1"}]},
+    {"testItemId": 12, "uniqueId": "u12", "issueType": "ti001", "logs": [
+      {"logId": 102, "logLevel": 40000, "message": "Connection refused to database host 10.0.0.2"},
+      {"logId": 103, "logLevel": 30000, "message": "Connection refused to database host 10.0.0.3"}]}
+  ]
+}]`
+
+func memorySearchConfig() *SearchConfig {
+	cfg := defaultSearchConfig()
+	cfg.MinDocFreq = 1
+	return cfg
+}
+
+func TestMemoryBackendIndexLogs(t *testing.T) {
+	b := NewMemoryBackend(memorySearchConfig())
+
+	rs, err := b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+	assert.Len(t, rs.Items, 3)
+	assert.Equal(t, http.StatusCreated, rs.Items[0].Index.Status)
+
+	rs, err = b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", rs.Items[0].Index.Result)
+}
+
+func TestMemoryBackendAnalyzeLogs(t *testing.T) {
+	tests := []struct {
+		analyzeRq     string
+		expectedIssue string
+		relevantItem  int64
+	}{
+		{
+			analyzeRq: `[{"launchId": 2, "project": 5, "launchName": "regression", "analyzerConfig": {"analyzerMode": "ALL"},
+				"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+				{"logId": 200, "logLevel": 40000, "message": "Connection refused to database host 10.0.0.9"}]}]}]`,
+			expectedIssue: "PB001",
+			relevantItem:  11,
+		},
+		{
+			analyzeRq: `[{"launchId": 2, "project": 5, "launchName": "regression", "analyzerConfig": {"analyzerMode": "ALL"},
+				"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+				{"logId": 200, "logLevel": 40000, "message": "NullPointerException at service.login line 43"}]}]}]`,
+			expectedIssue: "AB001",
+			relevantItem:  10,
+		},
+		{
+			analyzeRq: `[{"launchId": 2, "project": 5, "launchName": "other", "analyzerConfig": {"analyzerMode": "LAUNCH_NAME"},
+				"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+				{"logId": 200, "logLevel": 40000, "message": "NullPointerException at service.login line 43"}]}]}]`,
+		},
+		{
+			analyzeRq: `[{"launchId": 2, "project": 5, "launchName": "regression", "analyzerConfig": {"analyzerMode": "CURRENT_LAUNCH"},
+				"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+				{"logId": 200, "logLevel": 40000, "message": "NullPointerException at service.login line 43"}]}]}]`,
+		},
+		{
+			analyzeRq: `[{"launchId": 2, "project": 6, "launchName": "regression",
+				"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+				{"logId": 200, "logLevel": 40000, "message": "NullPointerException at service.login line 43"}]}]}]`,
+		},
+	}
+
+	b := NewMemoryBackend(memorySearchConfig())
+	_, err := b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+
+	for _, test := range tests {
+		results, err := b.AnalyzeLogs(parseLaunchesFixture(t, test.analyzeRq))
+		assert.NoError(t, err)
+
+		if test.expectedIssue == "" {
+			assert.Empty(t, results)
+		} else if assert.Len(t, results, 1) {
+			assert.Equal(t, test.expectedIssue, results[0].IssueType)
+			assert.Equal(t, test.relevantItem, results[0].RelevantItem)
+		}
+	}
+}
+
+func TestMemoryBackendAnalyzeLogsExplain(t *testing.T) {
+	b := NewMemoryBackend(memorySearchConfig())
+	_, err := b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+
+	results, err := b.AnalyzeLogs(parseLaunchesFixture(t, `[{"launchId": 2, "project": 5, "launchName": "regression",
+		"analyzerConfig": {"analyzerMode": "ALL"}, "explain": true,
+		"testItems": [{"testItemId": 20, "uniqueId": "u20", "issueType": "ti001", "logs": [
+		{"logId": 200, "logLevel": 40000, "message": "Connection refused to database host 10.0.0.9"}]}]}]`))
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 1) || !assert.NotNil(t, results[0].Explanation) {
+		return
+	}
+
+	e := results[0].Explanation
+	assert.Len(t, e.Queries, 1)
+	assert.Equal(t, "PB001", e.IssueTypes[0].IssueType)
+	if assert.NotEmpty(t, e.Hits) {
+		assert.Equal(t, int64(101), e.Hits[0].LogID)
+		assert.Contains(t, e.Hits[0].MatchedTerms, MatchedTerm{Field: "message", Term: "refused"})
+	}
+}
+
+func TestMemoryBackendSearchLogs(t *testing.T) {
+	b := NewMemoryBackend(memorySearchConfig())
+	_, err := b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+
+	ids, err := b.SearchLogs(SearchLogs{
+		ProjectID:         5,
+		ItemID:            20,
+		FilteredLaunchIds: []int64{1},
+		LogMessages:       []string{"Connection refused to database host 10.0.0.7"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{102}, ids)
+
+	_, err = b.DeleteLogs(&CleanIndex{Project: 5, IDs: []int64{102}})
+	assert.NoError(t, err)
+	ids, err = b.SearchLogs(SearchLogs{
+		ProjectID:         5,
+		ItemID:            20,
+		FilteredLaunchIds: []int64{1},
+		LogMessages:       []string{"Connection refused to database host 10.0.0.7"},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestMemoryBackendDeleteIndex(t *testing.T) {
+	b := NewMemoryBackend(memorySearchConfig())
+	_, err := b.IndexLogs(parseLaunchesFixture(t, memoryIndexRq))
+	assert.NoError(t, err)
+
+	rs, err := b.DeleteIndex(5)
+	assert.NoError(t, err)
+	assert.True(t, rs.Acknowledged)
+
+	rs, err = b.DeleteIndex(5)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rs.Status)
+}
+
+func Test_minimumShouldMatch(t *testing.T) {
+	assert.Equal(t, 3, minimumShouldMatch(3, "5<80%"))
+	assert.Equal(t, 8, minimumShouldMatch(10, "5<80%"))
+	assert.Equal(t, 2, minimumShouldMatch(10, "2"))
+	assert.Equal(t, 9, minimumShouldMatch(10, "98%"))
+}
+
+func parseLaunchesFixture(t *testing.T, rq string) []Launch {
+	launches := []Launch{}
+	assert.NoError(t, json.Unmarshal([]byte(rq), &launches))
+	return launches
+}
@@ -0,0 +1,168 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "analyzer"
+
+//Outcomes of processed messages and requests
+const (
+	outcomeSuccess   = "success"
+	outcomeFailure   = "failure"
+	outcomeCancelled = "cancelled"
+)
+
+var (
+	messagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "amqp_messages_total",
+		Help:      "Number of AMQP messages consumed by queue and processing outcome",
+	}, []string{"queue", "outcome"})
+
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Duration of request handling by transport and operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"transport", "operation"})
+
+	esRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "es_request_duration_seconds",
+		Help:      "Latency of requests to ES by operation and response status",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+
+	bulkItemFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "es_bulk_item_failures_total",
+		Help:      "Number of bulk indexing items failed after retries by item status",
+	}, []string{"status"})
+
+	predictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "predictions_total",
+		Help:      "Number of analyzed test items by whether issue type has been predicted or skipped",
+	}, []string{"result"})
+
+	amqpReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "amqp_reconnects_total",
+		Help:      "Number of restored AMQP connections",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(messagesConsumed, handlerDuration, esRequestDuration, bulkItemFailures, predictions, amqpReconnects)
+}
+
+//operationKey is a context key of ES operation name used to label ES metrics
+type operationKey struct{}
+
+//withOperation names ES operation requests of the context belong to
+func withOperation(ctx context.Context, op string) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+//operation obtains name of ES operation of the context
+func operation(ctx context.Context) string {
+	if op, ok := ctx.Value(operationKey{}).(string); ok {
+		return op
+	}
+	return "unknown"
+}
+
+//observeESRequest records latency of single request to ES. Requests failed before response is received are labeled as 'error'
+func observeESRequest(ctx context.Context, start time.Time, status int, err error) {
+	label := strconv.Itoa(status)
+	if nil != err {
+		label = "error"
+	}
+	esRequestDuration.WithLabelValues(operation(ctx), label).Observe(time.Since(start).Seconds())
+}
+
+//observeBulkItems counts items of bulk response rejected by ES. Items ES has not reported result of are counted as missing
+func observeBulkItems(rs *BulkResponse) {
+	for _, item := range rs.Items {
+		switch status := item.Index.Status; {
+		case 0 == status:
+			bulkItemFailures.WithLabelValues("missing").Inc()
+		case status >= http.StatusMultipleChoices:
+			bulkItemFailures.WithLabelValues(strconv.Itoa(status)).Inc()
+		}
+	}
+}
+
+//observePredictions counts test items issue type has been predicted for and the rest of them as skipped
+func observePredictions(launches []Launch, results []AnalysisResult) {
+	items := 0
+	for _, lc := range launches {
+		items += len(lc.TestItems)
+	}
+	made := 0
+	for _, rs := range results {
+		//explained test items may be reported without prediction
+		if "" != rs.IssueType {
+			made++
+		}
+	}
+	predictions.WithLabelValues("made").Add(float64(made))
+	if items > made {
+		predictions.WithLabelValues("skipped").Add(float64(items - made))
+	}
+}
+
+//outcome classifies processing error
+func outcome(err error) string {
+	switch {
+	case nil == err:
+		return outcomeSuccess
+	case isCancelled(err):
+		return outcomeCancelled
+	default:
+		return outcomeFailure
+	}
+}
+
+//instrumentDelivery records number and duration of messages processed from the queue
+func instrumentDelivery(queue string, msgCallback func(amqp.Delivery) error) func(amqp.Delivery) error {
+	return func(d amqp.Delivery) error {
+		start := time.Now()
+		err := msgCallback(d)
+		handlerDuration.WithLabelValues("amqp", queue).Observe(time.Since(start).Seconds())
+		messagesConsumed.WithLabelValues(queue, outcome(err)).Inc()
+		return err
+	}
+}
+
+//instrumentHTTP records duration of HTTP request handling
+func instrumentHTTP(op string, f func(w http.ResponseWriter, rq *http.Request) error) func(w http.ResponseWriter, rq *http.Request) error {
+	return func(w http.ResponseWriter, rq *http.Request) error {
+		start := time.Now()
+		defer func() {
+			handlerDuration.WithLabelValues("http", op).Observe(time.Since(start).Seconds())
+		}()
+		return f(w, rq)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/reportportal/commons-go/commons"
+	"github.com/reportportal/commons-go/conf"
+	"github.com/reportportal/commons-go/server"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObserveBulkItems(t *testing.T) {
+	rs := &BulkResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"errors":true,"items":[
+		{"index":{"_id":"1","status":201}},
+		{"index":{"_id":"2","status":429}},
+		{"index":{"_id":"3","status":400}},
+		{"index":{"_id":"4","status":429}},
+		{"index":{"_id":"5"}}]}`), rs))
+
+	rejected := testutil.ToFloat64(bulkItemFailures.WithLabelValues("429"))
+	invalid := testutil.ToFloat64(bulkItemFailures.WithLabelValues("400"))
+	missing := testutil.ToFloat64(bulkItemFailures.WithLabelValues("missing"))
+	observeBulkItems(rs)
+
+	assert.Equal(t, rejected+2, testutil.ToFloat64(bulkItemFailures.WithLabelValues("429")))
+	assert.Equal(t, invalid+1, testutil.ToFloat64(bulkItemFailures.WithLabelValues("400")))
+	assert.Equal(t, missing+1, testutil.ToFloat64(bulkItemFailures.WithLabelValues("missing")))
+}
+
+func TestObservePredictions(t *testing.T) {
+	made := testutil.ToFloat64(predictions.WithLabelValues("made"))
+	skipped := testutil.ToFloat64(predictions.WithLabelValues("skipped"))
+
+	observePredictions([]Launch{
+		{TestItems: []TestItem{{TestItemID: 1}, {TestItemID: 2}}},
+		{TestItems: []TestItem{{TestItemID: 3}}},
+	}, []AnalysisResult{{TestItem: 2, IssueType: "PB001"}, {TestItem: 3, Explanation: &Explanation{}}})
+
+	assert.Equal(t, made+1, testutil.ToFloat64(predictions.WithLabelValues("made")))
+	assert.Equal(t, skipped+2, testutil.ToFloat64(predictions.WithLabelValues("skipped")))
+}
+
+func TestInstrumentDelivery(t *testing.T) {
+	succeeded := testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeSuccess))
+	failed := testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeFailure))
+	cancelled := testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeCancelled))
+
+	for _, err := range []error{nil, errors.New("ES is down"), errors.WithStack(context.Canceled)} {
+		err := err
+		cb := instrumentDelivery("metrics", func(amqp.Delivery) error { return err })
+		assert.Equal(t, err, cb(amqp.Delivery{}))
+	}
+
+	assert.Equal(t, succeeded+1, testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeSuccess)))
+	assert.Equal(t, failed+1, testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeFailure)))
+	assert.Equal(t, cancelled+1, testutil.ToFloat64(messagesConsumed.WithLabelValues("metrics", outcomeCancelled)))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	i := 0
+	ts := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/2/_msearch",
+			rq:     msearchRq(2, getFixture(SearchRq)),
+			rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(OneHitSearchRs)),
+			status: http.StatusOK,
+		},
+	}, &i)
+	defer ts.Close()
+
+	srv := server.New(conf.EmptyConfig(), &commons.BuildInfo{})
+	initHTTPHandlers(srv, NewRequestHandler(newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())), false)
+
+	var router http.Handler
+	srv.WithRouter(func(mux *chi.Mux) {
+		router = mux
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(getFixture(LaunchWTestItemsWLogs))))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	metrics := rr.Body.String()
+	assert.Contains(t, metrics, `analyzer_es_request_duration_seconds_count{operation="analyze_logs",status="200"}`)
+	assert.Contains(t, metrics, `analyzer_handler_duration_seconds_count{operation="analyze",transport="http"}`)
+	assert.Contains(t, metrics, `analyzer_predictions_total{result="made"}`)
+}
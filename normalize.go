@@ -0,0 +1,202 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+//Supported normalization steps
+const (
+	NormalizeException = "exception"
+	NormalizeUUID      = "uuid"
+	NormalizeHex       = "hex"
+	NormalizeTimestamp = "timestamp"
+	NormalizeIP        = "ip"
+	NormalizeURL       = "url"
+	NormalizePath      = "path"
+	NormalizeQuoted    = "quoted"
+	NormalizeDigits    = "digits"
+	NormalizeFrames    = "frames"
+)
+
+var (
+	uuidRe      = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	hexRe       = regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b`)
+	hashCodeRe  = regexp.MustCompile(`@[0-9a-fA-F]{4,}\b`)
+	timestampRe = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)?\b|\b\d{2}:\d{2}:\d{2}(?:[.,]\d+)?\b`)
+	ipRe        = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`)
+	urlRe       = regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'"<>()]+`)
+	pathRe      = regexp.MustCompile(`(?:\b[A-Za-z]:|\B)[\\/](?:[\w.$-]+[\\/])+[\w.$-]+(?::\d+(?::\d+)?\b)?`)
+	lineNumRe   = regexp.MustCompile(`(\.\w+):\d+(?::\d+)?\b`)
+	quotedRe    = regexp.MustCompile(`"[^"\n]*"|\B'[^'\n]*'\B`)
+	digitsRe    = regexp.MustCompile(`\d+`)
+	moreRe      = regexp.MustCompile(`^\s*\.\.\.\s*\d*\s*(?:more|common frames omitted)\s*$`)
+	frameRe     = regexp.MustCompile(`^\s+at\s+\S|^\s*File "[^"]+", line \d+`)
+	exceptionRe = regexp.MustCompile(`^\s*(?:Caused by:\s*)?((?:[\w$]+\.)*[\w$]*(?:Exception|Error|Throwable))(?::\s*(.*))?$`)
+)
+
+//normalizationSteps replace variable parts of log message with constant placeholders
+var normalizationSteps = map[string]func(string) string{
+	NormalizeUUID: func(s string) string {
+		return uuidRe.ReplaceAllString(s, "UUID")
+	},
+	NormalizeHex: func(s string) string {
+		return hashCodeRe.ReplaceAllString(hexRe.ReplaceAllString(s, "HEX"), "@HEX")
+	},
+	NormalizeTimestamp: func(s string) string {
+		return timestampRe.ReplaceAllString(s, "TIMESTAMP")
+	},
+	NormalizeIP: func(s string) string {
+		return ipRe.ReplaceAllString(s, "IPADDR")
+	},
+	NormalizeURL: func(s string) string {
+		return urlRe.ReplaceAllString(s, "URL")
+	},
+	NormalizePath: func(s string) string {
+		return lineNumRe.ReplaceAllString(pathRe.ReplaceAllString(s, "FILEPATH"), "$1")
+	},
+	NormalizeQuoted: func(s string) string {
+		return quotedRe.ReplaceAllString(s, "QUOTED")
+	},
+	NormalizeDigits: func(s string) string {
+		return digitsRe.ReplaceAllString(s, "")
+	},
+	NormalizeFrames: collapseFrames,
+}
+
+//Normalizer is a pipeline of steps applied to log message before it's indexed or searched.
+//The same normalizer must be used at index and analyze time so similar messages produce the same terms
+type Normalizer struct {
+	steps []func(string) string
+	//exception keeps root cause exception even if it's out of analyzed lines
+	exception bool
+}
+
+//NewNormalizer creates normalizer applying steps in provided order
+func NewNormalizer(steps []string) (*Normalizer, error) {
+	n := &Normalizer{}
+	for _, name := range steps {
+		name = strings.TrimSpace(name)
+		if NormalizeException == name {
+			n.exception = true
+			continue
+		}
+		step, ok := normalizationSteps[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown normalization step: %s", name)
+		}
+		n.steps = append(n.steps, step)
+	}
+	return n, nil
+}
+
+//newNormalizer creates normalizer configured by search config
+//falls back to digits removal if configuration is invalid
+func newNormalizer(cfg *SearchConfig) *Normalizer {
+	n, err := NewNormalizer(cfg.Normalization)
+	if nil != err {
+		log.Warnf("%v. Only digits are removed from log messages", err)
+		n, _ = NewNormalizer([]string{NormalizeDigits})
+	}
+	return n
+}
+
+//Normalize normalizes first lines of the message
+func (n *Normalizer) Normalize(message string, lines int) string {
+	text := firstLines(message, lines)
+	if n.exception {
+		if class, msg := extractException(message); "" != class && !strings.Contains(text, class) {
+			text = text + "\n" + strings.TrimSpace(class+" "+msg)
+		}
+	}
+	return n.apply(text)
+}
+
+//parsedLog is a log message split into fields which are indexed and searched separately
+//Message is the normalized message as is. Rest of the fields are filled only if exception is found
+type parsedLog struct {
+	Message          string
+	ExceptionType    string
+	ExceptionMessage string
+	StackTrace       string
+	Text             string
+}
+
+//Parse splits the message into root cause exception, top stack frames and remaining text
+func (n *Normalizer) Parse(message string, lines, frames int) parsedLog {
+	pl := parsedLog{Message: n.Normalize(message, lines)}
+	class, msg := extractException(message)
+	if "" == class {
+		return pl
+	}
+
+	var stack, text []string
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case frameRe.MatchString(line):
+			if len(stack) < frames {
+				stack = append(stack, strings.TrimSpace(line))
+			}
+		case "" == strings.TrimSpace(line) || exceptionRe.MatchString(line) || moreRe.MatchString(line):
+		default:
+			text = append(text, line)
+		}
+	}
+
+	pl.ExceptionType = class
+	pl.ExceptionMessage = n.apply(msg)
+	pl.StackTrace = n.apply(strings.Join(stack, "\n"))
+	pl.Text = n.apply(strings.Join(text, "\n"))
+	return pl
+}
+
+func (n *Normalizer) apply(text string) string {
+	for _, step := range n.steps {
+		text = step(text)
+	}
+	return text
+}
+
+//extractException finds root cause exception class and its message
+//Since causes follow the exception in stack traces, the last one found is the root cause
+func extractException(message string) (class, msg string) {
+	for _, line := range strings.Split(message, "\n") {
+		if m := exceptionRe.FindStringSubmatch(strings.TrimRight(line, "\r")); nil != m {
+			class, msg = m[1], strings.TrimSpace(m[2])
+		}
+	}
+	return class, msg
+}
+
+//collapseFrames removes consecutive duplicated lines and omitted frames notes of stack traces
+func collapseFrames(s string) string {
+	lines := strings.Split(s, "\n")
+	collapsed := lines[:0]
+	prev := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if moreRe.MatchString(line) || (i > 0 && trimmed == prev && "" != trimmed) {
+			continue
+		}
+		collapsed = append(collapsed, line)
+		prev = trimmed
+	}
+	return strings.Join(collapsed, "\n")
+}
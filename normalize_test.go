@@ -0,0 +1,147 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []string
+		message string
+		lines   int
+		want    string
+	}{
+		{
+			name:    "uuid",
+			steps:   []string{NormalizeUUID},
+			message: "Session 123e4567-e89b-12d3-a456-426655440000 expired",
+			want:    "Session UUID expired",
+		},
+		{
+			name:    "hex",
+			steps:   []string{NormalizeHex},
+			message: "Segfault at 0x7ffd1c2a of com.epam.Foo@1b6d3586",
+			want:    "Segfault at HEX of com.epam.Foo@HEX",
+		},
+		{
+			name:    "timestamp",
+			steps:   []string{NormalizeTimestamp},
+			message: "Started 2019-10-01T12:30:45.123Z finished 2019-10-01 12:31:00 at 12:31:02,5",
+			want:    "Started TIMESTAMP finished TIMESTAMP at TIMESTAMP",
+		},
+		{
+			name:    "ip",
+			steps:   []string{NormalizeIP},
+			message: "Connection refused to 10.0.0.1:5432",
+			want:    "Connection refused to IPADDR",
+		},
+		{
+			name:    "url",
+			steps:   []string{NormalizeURL},
+			message: "GET https://rp.epam.com/api/v1/launch?id=5 returned 500",
+			want:    "GET URL returned 500",
+		},
+		{
+			name:    "path",
+			steps:   []string{NormalizePath},
+			message: "Failed at /home/user/tests/login_test.py:42\n\tat com.epam.Login.run(Login.java:15)",
+			want:    "Failed at FILEPATH\n\tat com.epam.Login.run(Login.java)",
+		},
+		{
+			name:    "quoted",
+			steps:   []string{NormalizeQuoted},
+			message: `Expected "admin" but was 'guest', can't login`,
+			want:    `Expected QUOTED but was QUOTED, can't login`,
+		},
+		{
+			name:    "frames",
+			steps:   []string{NormalizeDigits, NormalizeFrames},
+			message: "Error\n\tat Foo.bar(Foo.java:1)\n\tat Foo.bar(Foo.java:2)\n\tat Foo.baz(Foo.java:3)\n\t... 12 more",
+			want:    "Error\n\tat Foo.bar(Foo.java:)\n\tat Foo.baz(Foo.java:)",
+		},
+		{
+			name:    "root cause out of analyzed lines",
+			steps:   []string{NormalizeException},
+			message: "Test failed\n\tat Foo.bar(Foo.java:1)\nCaused by: java.net.ConnectException: Connection refused",
+			lines:   2,
+			want:    "Test failed\n\tat Foo.bar(Foo.java:1)\njava.net.ConnectException Connection refused",
+		},
+		{
+			name:    "root cause within analyzed lines",
+			steps:   []string{NormalizeException},
+			message: "java.net.ConnectException: Connection refused\n\tat Foo.bar(Foo.java:1)",
+			lines:   2,
+			want:    "java.net.ConnectException: Connection refused\n\tat Foo.bar(Foo.java:1)",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := NewNormalizer(tt.steps)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, n.Normalize(tt.message, tt.lines))
+		})
+	}
+}
+
+func TestNormalizerIsConfigurable(t *testing.T) {
+	_, err := NewNormalizer([]string{NormalizeDigits, "unknown"})
+	assert.Error(t, err)
+
+	n := newNormalizer(defaultSearchConfig())
+	assert.Equal(t, "Connection refused to IPADDR for UUID",
+		n.Normalize("Connection refused to 10.0.0.1 for 123e4567-e89b-12d3-a456-426655440000", -1))
+}
+
+func Test_extractException(t *testing.T) {
+	class, msg := extractException("org.openqa.selenium.WebDriverException: unknown error\n" +
+		"\tat org.openqa.Foo.bar(Foo.java:1)\n" +
+		"Caused by: java.lang.IllegalStateException: driver is closed\n" +
+		"\t... 3 more")
+	assert.Equal(t, "java.lang.IllegalStateException", class)
+	assert.Equal(t, "driver is closed", msg)
+
+	class, msg = extractException("Assertion failed")
+	assert.Empty(t, class)
+	assert.Empty(t, msg)
+}
+
+func TestNormalizerParse(t *testing.T) {
+	n, err := NewNormalizer([]string{NormalizeIP, NormalizeDigits})
+	assert.NoError(t, err)
+
+	message := "[main] Login test failed on 10.0.0.1\n" +
+		"org.openqa.selenium.WebDriverException: unknown error\n" +
+		"\tat org.openqa.Driver.get(Driver.java:12)\n" +
+		"\tat org.openqa.Driver.open(Driver.java:34)\n" +
+		"\tat com.epam.LoginTest.run(LoginTest.java:56)\n" +
+		"Caused by: java.net.ConnectException: Connection refused to 10.0.0.2\n" +
+		"\t... 3 more"
+
+	pl := n.Parse(message, -1, 2)
+	assert.Equal(t, n.Normalize(message, -1), pl.Message)
+	assert.Equal(t, "java.net.ConnectException", pl.ExceptionType)
+	assert.Equal(t, "Connection refused to IPADDR", pl.ExceptionMessage)
+	assert.Equal(t, "at org.openqa.Driver.get(Driver.java:)\nat org.openqa.Driver.open(Driver.java:)", pl.StackTrace)
+	assert.Equal(t, "[main] Login test failed on IPADDR", pl.Text)
+
+	pl = n.Parse("Assertion failed on 10.0.0.1", -1, 2)
+	assert.Equal(t, parsedLog{Message: "Assertion failed on IPADDR"}, pl)
+}
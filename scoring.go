@@ -0,0 +1,163 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"time"
+)
+
+//Supported scoring strategies
+const (
+	ScoringWeightedVote = "weighted_vote"
+	ScoringKNNMajority  = "knn_majority"
+	ScoringMaxScore     = "max_score"
+	ScoringTimeDecayed  = "time_decayed"
+)
+
+//ScoringStrategy accumulates scores of issue types out of top k hits of search result
+//Score is called once for each analyzed log of the test item
+type ScoringStrategy interface {
+	Score(rs *SearchResult, k int, scores map[string]*score)
+}
+
+//NewScoringStrategy creates scoring strategy by its name
+func NewScoringStrategy(name string, cfg *SearchConfig) (ScoringStrategy, error) {
+	switch name {
+	case ScoringWeightedVote, "":
+		return weightedVote{}, nil
+	case ScoringKNNMajority:
+		return knnMajority{}, nil
+	case ScoringMaxScore:
+		return maxScore{}, nil
+	case ScoringTimeDecayed:
+		return &timeDecayedVote{halfLife: cfg.DecayHalfLife, now: time.Now}, nil
+	default:
+		return nil, errors.Errorf("Unknown scoring strategy: %s", name)
+	}
+}
+
+//newScoringStrategy creates scoring strategy configured by search config
+//falls back to weighted vote if configuration is invalid
+func newScoringStrategy(cfg *SearchConfig) ScoringStrategy {
+	s, err := NewScoringStrategy(cfg.ScoringStrategy, cfg)
+	if nil != err {
+		log.Warnf("%v. Weighted vote is used", err)
+		return weightedVote{}
+	}
+	return s
+}
+
+//launchScoringStrategy resolves scoring strategy of the launch falling back to the default one.
+//It's resolved once per launch since analyzer config of the launch may override the default one
+func launchScoringStrategy(defaultStrategy ScoringStrategy, cfg *SearchConfig, conf AnalyzerConf) ScoringStrategy {
+	if "" == conf.ScoringStrategy {
+		return defaultStrategy
+	}
+	s, err := NewScoringStrategy(conf.ScoringStrategy, cfg)
+	if nil != err {
+		log.Warnf("%v. Default one is used", err)
+		return defaultStrategy
+	}
+	return s
+}
+
+//weightedVote sums up scores of hits normalized by total score of the result
+type weightedVote struct{}
+
+func (weightedVote) Score(rs *SearchResult, k int, scores map[string]*score) {
+	calculateScores(rs, k, scores)
+}
+
+//knnMajority gives single vote to each of top k hits
+type knnMajority struct{}
+
+func (knnMajority) Score(rs *SearchResult, k int, scores map[string]*score) {
+	for _, h := range topHits(rs, k) {
+		observeHit(scores, h).score++
+	}
+}
+
+//maxScore picks the highest score of hit relative to the top hit of the result
+type maxScore struct{}
+
+func (maxScore) Score(rs *SearchResult, k int, scores map[string]*score) {
+	hits := topHits(rs, k)
+	if len(hits) == 0 || hits[0].Score <= 0 {
+		return
+	}
+	top := hits[0].Score
+	for _, h := range hits {
+		s := observeHit(scores, h)
+		s.score = math.Max(s.score, h.Score/top)
+	}
+}
+
+//timeDecayedVote is a weighted vote where weight of the hit halves each halfLife since the log time.
+//Hits without log time are not decayed
+type timeDecayedVote struct {
+	halfLife time.Duration
+	now      func() time.Time
+}
+
+func (v *timeDecayedVote) Score(rs *SearchResult, k int, scores map[string]*score) {
+	hits := topHits(rs, k)
+	weights := make([]float64, len(hits))
+	total := 0.0
+	for i, h := range hits {
+		weights[i] = h.Score * v.decay(h)
+		total += weights[i]
+	}
+	if total <= 0 {
+		return
+	}
+	for i, h := range hits {
+		observeHit(scores, h).score += weights[i] / total
+	}
+}
+
+func (v *timeDecayedVote) decay(h Hit) float64 {
+	if nil == h.Source.LogTime || v.halfLife <= 0 {
+		return 1
+	}
+	age := v.now().Sub(*h.Source.LogTime)
+	if age <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(v.halfLife))
+}
+
+//topHits returns up to k hits of the result
+func topHits(rs *SearchResult, k int) []Hit {
+	hits := rs.Hits.Hits
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits
+}
+
+//observeHit obtains score of hit issue type keeping the hit with the highest score as most relevant
+func observeHit(scores map[string]*score, h Hit) *score {
+	s, ok := scores[h.Source.IssueType]
+	if !ok {
+		s = &score{mrHit: h}
+		scores[h.Source.IssueType] = s
+	} else if h.Score > s.mrHit.Score {
+		s.mrHit = h
+	}
+	return s
+}
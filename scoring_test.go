@@ -0,0 +1,116 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func scoringResult(hits ...Hit) *SearchResult {
+	rs := &SearchResult{}
+	rs.Hits.Hits = hits
+	rs.Hits.Total = Total{Value: len(hits), Relation: "eq"}
+	return rs
+}
+
+func scoredHit(id string, issueType string, s float64, logTime *time.Time) Hit {
+	h := Hit{ID: id, Score: s}
+	h.Source.IssueType = issueType
+	h.Source.LogTime = logTime
+	return h
+}
+
+func TestScoringStrategies(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-3 * 24 * time.Hour)
+	recent := now.Add(-time.Hour)
+
+	//single strong AB hit against several weak PB hits
+	rs := scoringResult(
+		scoredHit("1", "AB001", 9, &old),
+		scoredHit("2", "PB001", 2, &recent),
+		scoredHit("3", "PB001", 2, &recent),
+		scoredHit("4", "PB001", 2, &recent),
+	)
+
+	tests := []struct {
+		name     string
+		strategy ScoringStrategy
+		expected string
+	}{
+		{
+			name:     "weighted vote",
+			strategy: weightedVote{},
+			expected: "AB001",
+		},
+		{
+			name:     "knn majority",
+			strategy: knnMajority{},
+			expected: "PB001",
+		},
+		{
+			name:     "max score",
+			strategy: maxScore{},
+			expected: "AB001",
+		},
+		{
+			name:     "time decayed",
+			strategy: &timeDecayedVote{halfLife: 24 * time.Hour, now: func() time.Time { return now }},
+			expected: "PB001",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			scores := map[string]*score{}
+			tt.strategy.Score(rs, 10, scores)
+
+			rs := predictIssueType(&SearchConfig{}, 1, scores)
+			if assert.NotNil(t, rs) {
+				assert.Equal(t, tt.expected, rs.IssueType)
+			}
+			assert.Equal(t, "1", scores["AB001"].mrHit.ID)
+			assert.Equal(t, "2", scores["PB001"].mrHit.ID)
+		})
+	}
+}
+
+func TestScoringStrategiesRespectK(t *testing.T) {
+	rs := scoringResult(scoredHit("1", "AB001", 3, nil), scoredHit("2", "PB001", 2, nil))
+	for _, s := range []ScoringStrategy{weightedVote{}, knnMajority{}, maxScore{}, &timeDecayedVote{now: time.Now}} {
+		scores := map[string]*score{}
+		s.Score(rs, 1, scores)
+		assert.Len(t, scores, 1)
+		assert.Contains(t, scores, "AB001")
+	}
+}
+
+func Test_launchScoringStrategy(t *testing.T) {
+	cfg := &SearchConfig{ScoringStrategy: ScoringMaxScore}
+
+	defaultStrategy := newScoringStrategy(cfg)
+	assert.Equal(t, maxScore{}, defaultStrategy)
+	assert.Equal(t, weightedVote{}, newScoringStrategy(&SearchConfig{ScoringStrategy: "unknown"}))
+
+	assert.Equal(t, maxScore{}, launchScoringStrategy(defaultStrategy, cfg, AnalyzerConf{}))
+	assert.Equal(t, knnMajority{}, launchScoringStrategy(defaultStrategy, cfg, AnalyzerConf{ScoringStrategy: ScoringKNNMajority}))
+	assert.Equal(t, maxScore{}, launchScoringStrategy(defaultStrategy, cfg, AnalyzerConf{ScoringStrategy: "unknown"}))
+
+	_, err := NewScoringStrategy("unknown", cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/reportportal/commons-go/commons"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+)
+
+//Supported tracing exporters
+const (
+	TracingNone = "none"
+	TracingOTLP = "otlp"
+)
+
+//tracer creates spans of the analyzer. Spans are not recorded until tracer provider is configured
+var tracer = otel.Tracer("github.com/reportportal/service-analyzer")
+
+//TracingConfig specifies export of traces. Exporter endpoint and headers are configured
+//by standard OTEL_EXPORTER_OTLP_* environment variables
+type TracingConfig struct {
+	Exporter    string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	ServiceName string  `env:"TRACING_SERVICE_NAME" envDefault:"analyzer"`
+	SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+}
+
+//initTracing configures propagation of trace context and export of spans.
+//Trace context is propagated even if spans are not exported so callers are able to correlate replies
+func initTracing(lc fx.Lifecycle, cfg *AppConfig) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	switch cfg.TracingConfig.Exporter {
+	case TracingNone, "":
+		return nil
+	case TracingOTLP:
+	default:
+		return errors.Errorf("Unknown tracing exporter: %s", cfg.TracingConfig.Exporter)
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "Cannot create OTLP trace exporter")
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingConfig.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.TracingConfig.ServiceName),
+			attribute.String("service.version", commons.GetBuildInfo().Version),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			//pending spans are flushed before exit
+			return tp.Shutdown(ctx)
+		},
+	})
+	log.Infof("Traces are exported to OTLP collector with sample ratio %v", cfg.TracingConfig.SampleRatio)
+	return nil
+}
+
+//amqpHeadersCarrier adapts AMQP message headers to carry trace context
+type amqpHeadersCarrier amqp.Table
+
+func (c amqpHeadersCarrier) Get(key string) string {
+	if v, ok := c[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (c amqpHeadersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c amqpHeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+//deliveryContext extracts trace context of the caller the message is published by
+func deliveryContext(ctx context.Context, d amqp.Delivery) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, amqpHeadersCarrier(d.Headers))
+}
+
+//startDeliverySpan continues trace of the caller and starts span of the message processing
+func startDeliverySpan(ctx context.Context, d amqp.Delivery) (context.Context, trace.Span) {
+	return tracer.Start(deliveryContext(ctx, d), fmt.Sprintf("%s process", d.RoutingKey),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", d.RoutingKey),
+			attribute.String("messaging.message.conversation_id", d.CorrelationId),
+		))
+}
+
+//traceHeaders builds headers of the reply carrying trace context
+func traceHeaders(ctx context.Context, headers amqp.Table) amqp.Table {
+	if nil == headers {
+		headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, amqpHeadersCarrier(headers))
+	return headers
+}
+
+//endSpan records error of the traced operation if any and ends the span
+func endSpan(span trace.Span, err error) {
+	if nil != err {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
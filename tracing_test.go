@@ -0,0 +1,109 @@
+/*
+* Copyright 2019 EPAM Systems
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	callerTraceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerTraceCtx = "00-" + callerTraceID + "-00f067aa0ba902b7-01"
+)
+
+var (
+	spansOnce sync.Once
+	spans     *tracetest.InMemoryExporter
+)
+
+//recordSpans makes spans of the analyzer recorded. Tracer provider can be set only once
+//since tracer of the analyzer is bound to the first one
+func recordSpans() *tracetest.InMemoryExporter {
+	spansOnce.Do(func() {
+		spans = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	spans.Reset()
+	return spans
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	recorded := recordSpans()
+
+	d := amqp.Delivery{RoutingKey: "analyze", Headers: amqp.Table{"traceparent": callerTraceCtx}}
+	ctx, span := startDeliverySpan(context.Background(), d)
+	assert.Equal(t, callerTraceID, span.SpanContext().TraceID().String())
+
+	headers := traceHeaders(ctx, amqp.Table{errorReplyHeader: true})
+	assert.Equal(t, true, headers[errorReplyHeader])
+	traceparent, ok := headers["traceparent"].(string)
+	if assert.True(t, ok) {
+		assert.True(t, strings.HasPrefix(traceparent, "00-"+callerTraceID+"-"))
+		//reply refers to processing span rather than the caller one
+		assert.Contains(t, traceparent, span.SpanContext().SpanID().String())
+	}
+	endSpan(span, nil)
+
+	if assert.Len(t, recorded.GetSpans(), 1) {
+		s := recorded.GetSpans()[0]
+		assert.Equal(t, "analyze process", s.Name)
+		assert.Equal(t, trace.SpanKindConsumer, s.SpanKind)
+		assert.Equal(t, "00f067aa0ba902b7", s.Parent.SpanID().String())
+	}
+}
+
+func TestAnalyzeLogsSpans(t *testing.T) {
+	recorded := recordSpans()
+
+	i := 0
+	ts := startServer(t, []ServerCall{
+		{
+			method: "GET",
+			uri:    "/2/_msearch",
+			rq:     msearchRq(2, getFixture(SearchRq)),
+			rs:     msearchRs(getFixture(NoHitsSearchRs), getFixture(OneHitSearchRs)),
+			status: http.StatusOK,
+		},
+	}, &i)
+	defer ts.Close()
+
+	c := newTestClient(t, []string{ts.URL}, defaultClientConfig(), defaultSearchConfig())
+	ctx, parent := startDeliverySpan(context.Background(), amqp.Delivery{RoutingKey: "analyze"})
+	_, err := c.AnalyzeLogsContext(ctx, parseLaunchesFixture(t, getFixture(LaunchWTestItemsWLogs)))
+	assert.NoError(t, err)
+	endSpan(parent, err)
+
+	names := map[string]bool{}
+	for _, s := range recorded.GetSpans() {
+		names[s.Name] = true
+		assert.Equal(t, parent.SpanContext().TraceID(), s.SpanContext.TraceID(), s.Name)
+	}
+	assert.True(t, names["es analyze_logs"])
+	assert.True(t, names["scoring"])
+	assert.True(t, names["analyze process"])
+}